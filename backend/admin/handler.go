@@ -0,0 +1,354 @@
+package admin
+
+import (
+	"backend/auth"
+	"backend/role"
+	"backend/room"
+	"backend/user"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// AdminHandler is the REST counterpart of the CLI. Every endpoint calls the same service method as its CLI command,
+// so the two behave the same, except that changes to roles and user roles are limited by the role hierarchy like
+// anywhere else in the API. Only the CLI, which needs access to the server itself, can ignore it.
+type AdminHandler struct {
+	Otc         *auth.Otc
+	RoleService *role.Service
+	UserService *user.UserService
+	RoomService *room.RoomService
+}
+
+func NewAdminHandler(otc *auth.Otc, roleService *role.Service, userService *user.UserService, roomService *room.RoomService) *AdminHandler {
+	return &AdminHandler{
+		Otc:         otc,
+		RoleService: roleService,
+		UserService: userService,
+		RoomService: roomService,
+	}
+}
+
+func BindAdminRoutes(router *gin.Engine, handler *AdminHandler) {
+	group := router.Group("/admin", role.RequirePermission(handler.RoleService, role.Administer))
+	group.POST("/otcs", handler.HandleMintOtc)
+	group.GET("/otcs", handler.HandleListOtcs)
+	group.GET("/otcs/:code", handler.HandleGetOtc)
+	group.DELETE("/otcs/:code", handler.HandleRevokeOtc)
+	group.GET("/roles", handler.HandleListRoles)
+	group.POST("/roles", handler.HandleCreateRole)
+	group.PATCH("/roles/:roleName", handler.HandleUpdateRole)
+	group.DELETE("/roles/:roleName", handler.HandleDeleteRole)
+	group.GET("/users/:username/roles", handler.HandleListUserRoles)
+	group.PUT("/users/:username/roles/:roleName", handler.HandleAssignUserRole)
+	group.DELETE("/users/:username/roles/:roleName", handler.HandleRemoveUserRole)
+	group.PUT("/rooms/:roomName/roles/:roleName", handler.HandleAssignRoomRole)
+	group.DELETE("/rooms/:roomName/roles/:roleName", handler.HandleRemoveRoomRole)
+}
+
+// statusFor maps the errors of the admin service methods to a status code
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, role.ErrRoleNotFound), errors.Is(err, user.ErrUserNotFound), errors.Is(err, room.ErrRoomNotFound),
+		errors.Is(err, auth.ErrOtcNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, role.ErrRoleExists), errors.Is(err, role.ErrProtectedRole), errors.Is(err, role.ErrRoleInUse):
+		return http.StatusConflict
+	case errors.Is(err, room.ErrConflictingOverride), errors.Is(err, auth.ErrInvalidOtcRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, role.ErrRoleTooHigh), errors.Is(err, role.ErrUserTooHigh), errors.Is(err, role.ErrEscalation):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// HandleMintOtc is the otc command. The body, a CreateOtcRequest, is optional. Roles the code comes with must be ones
+// the caller could grant themselves.
+func (h *AdminHandler) HandleMintOtc(c *gin.Context) {
+	var request auth.CreateOtcRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := role.CanGrantRoles(c, h.RoleService, request.Roles); err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	var createdBy *uuid.UUID
+	if userId, exists := c.Get("user_id"); exists {
+		id := userId.(uuid.UUID)
+		createdBy = &id
+	}
+	otc, err := h.Otc.Create(c, request, createdBy)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"otc": otc.Code, "invite": otc})
+}
+
+// HandleListOtcs is otc ls
+func (h *AdminHandler) HandleListOtcs(c *gin.Context) {
+	otcs, err := h.Otc.List(c)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"otcs": otcs})
+}
+
+// HandleGetOtc is otc show, which includes who signed up with the OTC
+func (h *AdminHandler) HandleGetOtc(c *gin.Context) {
+	code, err := uuid.Parse(c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OTC"})
+		return
+	}
+	otc, err := h.Otc.Get(c, code)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"otc": otc})
+}
+
+// HandleRevokeOtc is otc revoke
+func (h *AdminHandler) HandleRevokeOtc(c *gin.Context) {
+	code, err := uuid.Parse(c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OTC"})
+		return
+	}
+	err = h.Otc.Revoke(c, code)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+// HandleListRoles is role ls
+func (h *AdminHandler) HandleListRoles(c *gin.Context) {
+	roles, err := h.RoleService.GetAllRoles()
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// HandleCreateRole is role make. The body is {"name": "..."}.
+func (h *AdminHandler) HandleCreateRole(c *gin.Context) {
+	var request role.CreateRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	actor, err := role.GetActor(c, h.RoleService)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	// CreateRole makes a role without permissions at position 1
+	if err := actor.CanGrant(role.Role{Position: 1}); err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := h.RoleService.CreateRole(request.Name)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"role": created})
+}
+
+// HandleUpdateRole is role perms and role pos, for whichever of permissions and position the body sets. Both the role
+// as it is and as it would be must be within what the caller can grant.
+func (h *AdminHandler) HandleUpdateRole(c *gin.Context) {
+	var request role.UpdateRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Position != nil && *request.Position < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position can't be negative"})
+		return
+	}
+
+	actor, err := role.GetActor(c, h.RoleService)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	existing, err := h.RoleService.GetRole(c, c.Param("roleName"))
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	proposed := *existing
+	if request.Permissions != nil {
+		proposed.Permissions = *request.Permissions
+	}
+	if request.Position != nil {
+		proposed.Position = *request.Position
+	}
+	err = actor.CanManage(*existing)
+	if err == nil {
+		err = actor.CanGrant(proposed)
+	}
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.RoleService.Update(c, existing.Name, request)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": updated})
+}
+
+// HandleDeleteRole is role delete
+func (h *AdminHandler) HandleDeleteRole(c *gin.Context) {
+	actor, err := role.GetActor(c, h.RoleService)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	existing, err := h.RoleService.GetRole(c, c.Param("roleName"))
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	if err := actor.CanManage(*existing); err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.RoleService.DeleteRole(existing.Name)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+// HandleListUserRoles is ur ls
+func (h *AdminHandler) HandleListUserRoles(c *gin.Context) {
+	roles, err := h.UserService.GetUserRolesByUsername(c, c.Param("username"))
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// HandleAssignUserRole is ur assign
+func (h *AdminHandler) HandleAssignUserRole(c *gin.Context) {
+	err := h.checkUserRoleChange(c, c.Param("username"), c.Param("roleName"), true)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.UserService.AssignUserToRole(c, c.Param("username"), c.Param("roleName"))
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+// HandleRemoveUserRole is ur remove
+func (h *AdminHandler) HandleRemoveUserRole(c *gin.Context) {
+	err := h.checkUserRoleChange(c, c.Param("username"), c.Param("roleName"), false)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.UserService.RemoveUserFromRole(c, c.Param("username"), c.Param("roleName"))
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+// checkUserRoleChange checks that the caller may give the role to the user, or take it away from them, with the same
+// rules as user.UserHandler
+func (h *AdminHandler) checkUserRoleChange(c *gin.Context, username string, roleName string, assign bool) error {
+	actor, err := role.GetActor(c, h.RoleService)
+	if err != nil {
+		return err
+	}
+	targetRole, err := h.RoleService.GetRole(c, roleName)
+	if err != nil {
+		return err
+	}
+	target, err := h.UserService.GetUserByUsername(c, username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	targetPosition, err := h.RoleService.PositionOf(c, target.UserID)
+	if err != nil {
+		return err
+	}
+
+	userId, _ := c.Get("user_id")
+	err = actor.CanManageUser(targetPosition, target.UserID == userId)
+	if err != nil {
+		return err
+	}
+	if assign {
+		return actor.CanGrant(*targetRole)
+	}
+	return actor.CanManage(*targetRole)
+}
+
+// HandleAssignRoomRole is assignroomrole. The body is {"allow": [...], "deny": [...]}; without a body the role is
+// allowed everything, as in the CLI.
+func (h *AdminHandler) HandleAssignRoomRole(c *gin.Context) {
+	request := room.SetRoomRoleRequest{Allow: role.AllRoomPermissions}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	err := h.RoomService.AssignRoomRole(c, c.Param("roomName"), c.Param("roleName"), request.Allow, request.Deny)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": room.RoomRole{Name: c.Param("roleName"), Allow: request.Allow, Deny: request.Deny}})
+}
+
+// HandleRemoveRoomRole is removeroomrole
+func (h *AdminHandler) HandleRemoveRoomRole(c *gin.Context) {
+	err := h.RoomService.RemoveRoomRole(c, c.Param("roomName"), c.Param("roleName"))
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,93 @@
+package attachment
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultMaxSize      = 10 << 20
+	DefaultStorageDir   = "attachments"
+	DefaultAllowedTypes = "image/png,image/jpeg,image/gif,image/webp,text/plain,application/pdf"
+)
+
+// Config controls what may be uploaded and where it is stored
+type Config struct {
+	// MaxSize is the largest accepted upload, in bytes
+	MaxSize int64
+	// AllowedTypes are MIME types like image/png. A type ending in /* allows the whole family.
+	AllowedTypes []string
+}
+
+// Allows reports whether files of the given MIME type may be uploaded
+func (c Config) Allows(contentType string) bool {
+	for _, allowed := range c.AllowedTypes {
+		if allowed == contentType {
+			return true
+		}
+		if family, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(contentType, family+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigFromEnv reads the upload limits and builds the blob store from the environment:
+//
+//	ATTACHMENT_MAX_BYTES      largest accepted upload (default 10 MiB)
+//	ATTACHMENT_ALLOWED_TYPES  comma-separated MIME types, e.g. image/*,application/pdf
+//	ATTACHMENT_STORE          "fs" (default) or "s3"
+//	ATTACHMENT_DIR            directory of the fs store (default ./attachments)
+//	S3_ENDPOINT, S3_BUCKET, S3_REGION, S3_ACCESS_KEY, S3_SECRET_KEY  for the s3 store
+func ConfigFromEnv() (Config, BlobStore, error) {
+	config := Config{
+		MaxSize:      DefaultMaxSize,
+		AllowedTypes: splitList(DefaultAllowedTypes),
+	}
+	if maxSize := os.Getenv("ATTACHMENT_MAX_BYTES"); maxSize != "" {
+		parsed, err := strconv.ParseInt(maxSize, 10, 64)
+		if err != nil || parsed <= 0 {
+			return config, nil, fmt.Errorf("invalid ATTACHMENT_MAX_BYTES %q", maxSize)
+		}
+		config.MaxSize = parsed
+	}
+	if allowedTypes := os.Getenv("ATTACHMENT_ALLOWED_TYPES"); allowedTypes != "" {
+		config.AllowedTypes = splitList(allowedTypes)
+	}
+
+	switch storeType := os.Getenv("ATTACHMENT_STORE"); storeType {
+	case "", "fs":
+		dir := os.Getenv("ATTACHMENT_DIR")
+		if dir == "" {
+			dir = DefaultStorageDir
+		}
+		store, err := NewFileSystemStore(dir)
+		return config, store, err
+	case "s3":
+		endpoint := os.Getenv("S3_ENDPOINT")
+		bucket := os.Getenv("S3_BUCKET")
+		if endpoint == "" || bucket == "" {
+			return config, nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required for the s3 attachment store")
+		}
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		store := NewS3Store(endpoint, bucket, region, os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"))
+		return config, store, nil
+	default:
+		return config, nil, fmt.Errorf("unknown ATTACHMENT_STORE %q", storeType)
+	}
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
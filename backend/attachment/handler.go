@@ -0,0 +1,259 @@
+package attachment
+
+import (
+	"backend/role"
+	"backend/room"
+	"backend/user"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// multipartOverhead allows for the multipart boundaries and form fields around the file itself
+const multipartOverhead = 64 << 10
+
+const maxFilenameLength = 255
+
+type AttachmentHandler struct {
+	AttachmentService *Service
+	UserService       *user.UserService
+	RoomService       *room.RoomService
+}
+
+func NewAttachmentHandler(attachmentService *Service, userService *user.UserService, roomService *room.RoomService) *AttachmentHandler {
+	return &AttachmentHandler{
+		AttachmentService: attachmentService,
+		UserService:       userService,
+		RoomService:       roomService,
+	}
+}
+
+func BindAttachmentRoutes(router *gin.Engine, attachmentHandler *AttachmentHandler) {
+	router.POST("/attachments", attachmentHandler.HandleUpload)
+	router.GET("/attachments/:id", attachmentHandler.HandleDownload)
+	router.GET("/attachments/:id/thumbnail", attachmentHandler.HandleDownloadThumbnail)
+}
+
+// canInRoom reports whether the user has every one of permissions in the room
+func (h *AttachmentHandler) canInRoom(
+	c *gin.Context,
+	userId uuid.UUID,
+	roomId uuid.UUID,
+	permissions role.RoomPermission,
+) (bool, error) {
+	audience, userRoles, err := h.getAudience(c, userId, roomId)
+	if audience == nil || err != nil {
+		return false, err
+	}
+	return audience.Can(userId, userRoles, permissions), nil
+}
+
+// getAudience returns the room's audience, or nil if there is no such room, and the user's roles
+func (h *AttachmentHandler) getAudience(c *gin.Context, userId uuid.UUID, roomId uuid.UUID) (*room.Audience, []string, error) {
+	userRoles, err := h.UserService.GetUserRoles(c.Request.Context(), userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	audience, err := h.RoomService.GetAudience(c, roomId)
+	if errors.Is(err, room.ErrRoomNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return audience, userRoles, nil
+}
+
+// HandleUpload stores a multipart upload with the file in "file" and the room it is for in "room_id". The type is
+// sniffed from the contents rather than trusted from the client.
+func (h *AttachmentHandler) HandleUpload(c *gin.Context) {
+	config := h.AttachmentService.Config
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.MaxSize+multipartOverhead)
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file is larger than " + strconv.FormatInt(config.MaxSize, 10) + " bytes"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > config.MaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file is larger than " + strconv.FormatInt(config.MaxSize, 10) + " bytes"})
+		return
+	}
+	if header.Size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is empty"})
+		return
+	}
+
+	roomId, err := uuid.Parse(c.Request.FormValue("room_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room_id"})
+		return
+	}
+	allowed, err := h.canInRoom(c, userId.(uuid.UUID), roomId, role.RoomPost|role.RoomAttach)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	sniffed := make([]byte, 512)
+	n, err := io.ReadFull(file, sniffed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(sniffed[:n]))
+	if err != nil || !config.Allows(contentType) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "file type " + contentType + " is not allowed"})
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := filepath.Base(strings.ReplaceAll(header.Filename, `\`, "/"))
+	if len(filename) > maxFilenameLength {
+		filename = filename[len(filename)-maxFilenameLength:]
+	}
+
+	attachment, err := h.AttachmentService.Create(c, roomId, userId.(uuid.UUID), filename, contentType, header.Size, file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attachment": attachment})
+}
+
+func (h *AttachmentHandler) HandleDownload(c *gin.Context) {
+	attachmentId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	attachment, storageKey, err := h.AttachmentService.Get(c, attachmentId)
+	if errors.Is(err, ErrAttachmentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	allowed, err := h.canInRoom(c, userId.(uuid.UUID), attachment.RoomID, role.RoomView)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		// Same as a missing attachment, so IDs in rooms the user can't see aren't confirmed to exist
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrAttachmentNotFound.Error()})
+		return
+	}
+
+	contents, err := h.AttachmentService.Open(c, storageKey)
+	if err != nil {
+		slog.Error("Failed to open attachment blob",
+			slog.String("attachment_id", attachmentId.String()),
+			slog.String("error", err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer contents.Close()
+
+	// Only images are shown inline; anything else is downloaded so it can't run in our origin
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		disposition = "inline"
+	}
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, contents, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, max-age=31536000, immutable",
+	})
+}
+
+// HandleDownloadThumbnail serves the thumbnail of an image attachment. Attachments without one, because they aren't
+// images or haven't been processed yet, are reported as not found.
+func (h *AttachmentHandler) HandleDownloadThumbnail(c *gin.Context) {
+	attachmentId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	attachment, thumbnailKey, thumbnailType, err := h.AttachmentService.GetThumbnail(c, attachmentId)
+	if errors.Is(err, ErrAttachmentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	allowed, err := h.canInRoom(c, userId.(uuid.UUID), attachment.RoomID, role.RoomView)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrAttachmentNotFound.Error()})
+		return
+	}
+
+	contents, err := h.AttachmentService.Open(c, thumbnailKey)
+	if err != nil {
+		slog.Error("Failed to open attachment thumbnail",
+			slog.String("attachment_id", attachmentId.String()),
+			slog.String("error", err.Error()),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer contents.Close()
+
+	c.DataFromReader(http.StatusOK, -1, thumbnailType, contents, map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, max-age=31536000, immutable",
+	})
+}
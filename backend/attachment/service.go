@@ -0,0 +1,224 @@
+package attachment
+
+import (
+	"backend/imaging"
+	"backend/model"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// Columns are the columns of open_discord.attachments scanned by ScanAttachment, in order
+const Columns = `id, room_id, uploader_id, message_id, filename, content_type, size, created_at, width, height, blurhash, thumbnail_key is not null`
+
+type Service struct {
+	DB     *pgxpool.Pool
+	Store  BlobStore
+	Config Config
+	// uploaded wakes the ImageWorker when an image is stored, so it doesn't wait for its next poll
+	uploaded chan struct{}
+}
+
+func NewAttachmentService(db *pgxpool.Pool, store BlobStore, config Config) *Service {
+	return &Service{
+		DB:       db,
+		Store:    store,
+		Config:   config,
+		uploaded: make(chan struct{}, 1),
+	}
+}
+
+// ScanAttachment scans Columns, followed by any extra columns selected after them
+func ScanAttachment(row pgx.Row, extra ...any) (*model.Attachment, error) {
+	var attachment model.Attachment
+	dest := []any{
+		&attachment.ID,
+		&attachment.RoomID,
+		&attachment.UploaderID,
+		&attachment.MessageID,
+		&attachment.Filename,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.CreatedAt,
+		&attachment.Width,
+		&attachment.Height,
+		&attachment.Blurhash,
+		&attachment.HasThumbnail,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// Create stores an upload and records it as an attachment in the room, not yet on any message. Location data is
+// stripped from images before they are stored, and their thumbnails are made in the background by the ImageWorker.
+func (s *Service) Create(
+	ctx context.Context,
+	roomId uuid.UUID,
+	uploaderId uuid.UUID,
+	filename string,
+	contentType string,
+	size int64,
+	contents io.Reader,
+) (*model.Attachment, error) {
+	attachment := model.Attachment{
+		ID:          uuid.New(),
+		RoomID:      roomId,
+		UploaderID:  uploaderId,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+	}
+	storageKey := attachment.ID.String()
+
+	if imaging.Supported(contentType) {
+		data, err := io.ReadAll(io.LimitReader(contents, size))
+		if err != nil {
+			return nil, err
+		}
+		if stripped, changed := imaging.StripGPS(data, contentType); changed {
+			slog.Info("Stripped location data from image", slog.String("attachment_id", attachment.ID.String()))
+			data = stripped
+		}
+		contents = bytes.NewReader(data)
+		size = int64(len(data))
+		attachment.Size = size
+	}
+
+	if err := s.Store.Put(ctx, storageKey, contents, size, contentType); err != nil {
+		return nil, err
+	}
+
+	err := s.DB.QueryRow(ctx,
+		`insert into open_discord.attachments (id, room_id, uploader_id, filename, content_type, size, storage_key)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		returning created_at`,
+		attachment.ID, roomId, uploaderId, filename, contentType, size, storageKey,
+	).Scan(&attachment.CreatedAt)
+	if err != nil {
+		// Don't leave an orphaned blob behind
+		if deleteErr := s.Store.Delete(ctx, storageKey); deleteErr != nil {
+			slog.Error("Failed to delete blob of failed upload",
+				slog.String("storage_key", storageKey),
+				slog.String("error", deleteErr.Error()),
+			)
+		}
+		return nil, err
+	}
+
+	slog.Info("Stored attachment",
+		slog.String("attachment_id", attachment.ID.String()),
+		slog.String("room_id", roomId.String()),
+		slog.Int64("size", size),
+	)
+	if imaging.Supported(contentType) {
+		select {
+		case s.uploaded <- struct{}{}:
+		default:
+		}
+	}
+	return &attachment, nil
+}
+
+// Get returns an attachment along with the key of its blob
+func (s *Service) Get(ctx context.Context, attachmentId uuid.UUID) (*model.Attachment, string, error) {
+	var storageKey string
+	attachment, err := ScanAttachment(s.DB.QueryRow(ctx,
+		`select `+Columns+`, storage_key from open_discord.attachments where id = $1`,
+		attachmentId,
+	), &storageKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return attachment, storageKey, nil
+}
+
+// GetThumbnail returns an attachment along with the key and type of its thumbnail, or ErrAttachmentNotFound if it
+// has none
+func (s *Service) GetThumbnail(ctx context.Context, attachmentId uuid.UUID) (*model.Attachment, string, string, error) {
+	var thumbnailKey, thumbnailType string
+	attachment, err := ScanAttachment(s.DB.QueryRow(ctx,
+		`select `+Columns+`, thumbnail_key, thumbnail_type
+		from open_discord.attachments
+		where id = $1 and thumbnail_key is not null`,
+		attachmentId,
+	), &thumbnailKey, &thumbnailType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", "", ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	return attachment, thumbnailKey, thumbnailType, nil
+}
+
+// Open returns the contents of an attachment's blob. The caller must close it.
+func (s *Service) Open(ctx context.Context, storageKey string) (io.ReadCloser, error) {
+	return s.Store.Get(ctx, storageKey)
+}
+
+// DeleteForMessage deletes the attachments of a message along with their blobs
+func (s *Service) DeleteForMessage(ctx context.Context, messageId uuid.UUID) error {
+	rows, err := s.DB.Query(ctx,
+		`delete from open_discord.attachments where message_id = $1 returning storage_key, thumbnail_key`,
+		messageId,
+	)
+	if err != nil {
+		return err
+	}
+	return s.deleteBlobs(ctx, rows)
+}
+
+// DeleteForRoom deletes every attachment in a room along with their blobs, including ones not yet sent with a message.
+// Call it before deleting the room, since that would delete the attachments without their blobs.
+func (s *Service) DeleteForRoom(ctx context.Context, roomId uuid.UUID) error {
+	rows, err := s.DB.Query(ctx,
+		`delete from open_discord.attachments where room_id = $1 returning storage_key, thumbnail_key`,
+		roomId,
+	)
+	if err != nil {
+		return err
+	}
+	return s.deleteBlobs(ctx, rows)
+}
+
+// deleteBlobs deletes the blobs of the storage_key and thumbnail_key rows returned by deleting attachments
+func (s *Service) deleteBlobs(ctx context.Context, rows pgx.Rows) error {
+	defer rows.Close()
+
+	var storageKeys []string
+	for rows.Next() {
+		var storageKey string
+		var thumbnailKey *string
+		if err := rows.Scan(&storageKey, &thumbnailKey); err != nil {
+			return err
+		}
+		storageKeys = append(storageKeys, storageKey)
+		if thumbnailKey != nil {
+			storageKeys = append(storageKeys, *thumbnailKey)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, storageKey := range storageKeys {
+		if err := s.Store.Delete(ctx, storageKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFileSystemStoreRoundTrip(t *testing.T) {
+	store, err := NewFileSystemStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSystemStore() error = %v", err)
+	}
+	ctx := context.Background()
+
+	contents := "hello, disk"
+	if err := store.Put(ctx, "some-key", strings.NewReader(contents), int64(len(contents)), "text/plain"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	blob, err := store.Get(ctx, "some-key")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got, _ := io.ReadAll(blob)
+	blob.Close()
+	if string(got) != contents {
+		t.Errorf("Get() = %q, want %q", got, contents)
+	}
+
+	if err := store.Delete(ctx, "some-key"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Delete(ctx, "some-key"); err != nil {
+		t.Errorf("deleting a missing blob should not fail, got %v", err)
+	}
+	if _, err := store.Get(ctx, "some-key"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, ErrBlobNotFound)
+	}
+}
+
+func TestFileSystemStoreRejectsShortUploads(t *testing.T) {
+	store, _ := NewFileSystemStore(t.TempDir())
+	ctx := context.Background()
+
+	err := store.Put(ctx, "some-key", strings.NewReader("short"), 100, "text/plain")
+	if err == nil {
+		t.Fatal("Put() of a truncated upload should fail")
+	}
+	if _, err := store.Get(ctx, "some-key"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("a failed upload left a blob behind: %v", err)
+	}
+}
+
+func TestFileSystemStoreRejectsEscapingKeys(t *testing.T) {
+	store, _ := NewFileSystemStore(t.TempDir())
+	for _, key := range []string{"../outside", "/etc/passwd", ""} {
+		if _, err := store.Get(context.Background(), key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q) error = %v, want %v", key, err, ErrInvalidKey)
+		}
+	}
+}
+
+func TestConfigAllows(t *testing.T) {
+	config := Config{AllowedTypes: []string{"image/*", "application/pdf"}}
+	tests := map[string]bool{
+		"image/png":       true,
+		"image/gif":       true,
+		"application/pdf": true,
+		"text/html":       false,
+		"imagex/png":      false,
+	}
+	for contentType, want := range tests {
+		if got := config.Allows(contentType); got != want {
+			t.Errorf("Allows(%v) = %v, want %v", contentType, got, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package attachment
+
+import (
+	"backend/imaging"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultWorkerInterval  = 30 * time.Second
+	defaultWorkerBatchSize = 10
+)
+
+// processableTypes are the attachment types the ImageWorker picks up
+var processableTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+// ImageWorker makes thumbnails of image attachments and records their dimensions and blurhash. It wakes up whenever
+// this instance stores an image and polls every Interval for anything uploaded through other instances or left over
+// from a restart. Several workers can run against the same database: each claims its batch with skip locked.
+type ImageWorker struct {
+	Service   *Service
+	Interval  time.Duration
+	BatchSize int
+}
+
+func NewImageWorker(service *Service) *ImageWorker {
+	return &ImageWorker{
+		Service:   service,
+		Interval:  defaultWorkerInterval,
+		BatchSize: defaultWorkerBatchSize,
+	}
+}
+
+// Run processes images until ctx is cancelled
+func (w *ImageWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		for {
+			processed, err := w.processBatch(ctx)
+			if err != nil {
+				slog.Error("Failed to process image attachments", slog.String("error", err.Error()))
+				break
+			}
+			if processed < w.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.Service.uploaded:
+		}
+	}
+}
+
+// processBatch claims and processes up to BatchSize unprocessed images, returning how many it claimed
+func (w *ImageWorker) processBatch(ctx context.Context) (int, error) {
+	tx, err := w.Service.DB.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`select id, content_type, storage_key
+		from open_discord.attachments
+		where processed_at is null and content_type = any($1)
+		order by created_at
+		limit $2
+		for update skip locked`,
+		processableTypes, w.BatchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id          uuid.UUID
+		contentType string
+		storageKey  string
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.contentType, &p.storageKey); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range batch {
+		if err := w.process(ctx, tx, p.id, p.contentType, p.storageKey); err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), tx.Commit(ctx)
+}
+
+// process analyzes one image and records the result. Images that can't be decoded, or are gone from the store, are
+// marked as processed with the reason so they aren't retried; any other store failure aborts the batch to retry later.
+func (w *ImageWorker) process(ctx context.Context, tx pgx.Tx, attachmentId uuid.UUID, contentType, storageKey string) error {
+	data, err := w.read(ctx, storageKey)
+	if err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return err
+	}
+	var analysis *imaging.Analysis
+	if err == nil {
+		analysis, err = imaging.Analyze(data, contentType)
+	}
+	if err != nil {
+		slog.Warn("Unable to process image attachment",
+			slog.String("attachment_id", attachmentId.String()),
+			slog.String("error", err.Error()),
+		)
+		_, err = tx.Exec(ctx,
+			`update open_discord.attachments set processed_at = now(), processing_error = $2 where id = $1`,
+			attachmentId, err.Error(),
+		)
+		return err
+	}
+
+	thumbnailKey := storageKey + ".thumb"
+	err = w.Service.Store.Put(ctx, thumbnailKey, bytes.NewReader(analysis.Thumbnail), int64(len(analysis.Thumbnail)), analysis.ThumbnailType)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`update open_discord.attachments
+		set width = $2, height = $3, blurhash = $4, thumbnail_key = $5, thumbnail_type = $6, processed_at = now()
+		where id = $1`,
+		attachmentId, analysis.Width, analysis.Height, analysis.Blurhash, thumbnailKey, analysis.ThumbnailType,
+	)
+	if err != nil {
+		return err
+	}
+	slog.Info("Processed image attachment",
+		slog.String("attachment_id", attachmentId.String()),
+		slog.Int("width", analysis.Width),
+		slog.Int("height", analysis.Height),
+	)
+	return nil
+}
+
+func (w *ImageWorker) read(ctx context.Context, storageKey string) ([]byte, error) {
+	blob, err := w.Service.Store.Get(ctx, storageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	return io.ReadAll(io.LimitReader(blob, w.Service.Config.MaxSize))
+}
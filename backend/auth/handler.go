@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"backend/role"
 	"backend/user"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -13,13 +15,29 @@ type AuthHandler struct {
 	Auth         *Service
 	Token        *TokenService
 	UserSerivice *user.UserService
+	Otc          *Otc
+	RoleService  *role.Service
+	Sessions     *SessionService
+	TwoFactor    *TwoFactorService
 }
 
-func NewAuthHandler(auth *Service, token *TokenService, userService *user.UserService) *AuthHandler {
+func NewAuthHandler(
+	auth *Service,
+	token *TokenService,
+	userService *user.UserService,
+	otc *Otc,
+	roleService *role.Service,
+	sessions *SessionService,
+	twoFactor *TwoFactorService,
+) *AuthHandler {
 	return &AuthHandler{
 		Auth:         auth,
 		Token:        token,
 		UserSerivice: userService,
+		Otc:          otc,
+		RoleService:  roleService,
+		Sessions:     sessions,
+		TwoFactor:    twoFactor,
 	}
 }
 
@@ -29,6 +47,21 @@ type SignInRequest struct {
 	Otc      uuid.UUID `json:"otc,omitempty"`
 }
 
+// TwoFactorSignInRequest finishes signing in with the challenge HandleSignIn returned and a code from the user's
+// authenticator app, or one of their recovery codes
+type TwoFactorSignInRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type CheckPasswordRequest struct {
 	Password string `json:"password"`
 }
@@ -40,14 +73,26 @@ type ChangePasswordRequest struct {
 
 const signupRoute = "/signup"
 const signInRoute = "/signin"
+const signInTwoFactorRoute = "/signin/2fa"
+const refreshRoute = "/refresh"
 const checkPasswordRoute = "/check_password"
 const changePasswordRoute = "/change_password"
 
 func BindAuthRoutes(router *gin.Engine, authHandler *AuthHandler) {
 	router.POST(signInRoute, authHandler.HandleSignIn)
+	router.POST(signInTwoFactorRoute, authHandler.HandleSignInTwoFactor)
+	router.POST(refreshRoute, authHandler.HandleRefresh)
+	router.GET("/sessions", authHandler.HandleGetSessions)
+	router.DELETE("/sessions/:sessionId", authHandler.HandleRevokeSession)
 	router.POST(signupRoute, authHandler.HandleSignUp)
 	router.POST(checkPasswordRoute, authHandler.CheckPassword)
 	router.POST(changePasswordRoute, authHandler.ChangePassword)
+	router.GET("/2fa", authHandler.HandleGetTwoFactor)
+	router.POST("/2fa/enroll", authHandler.HandleEnrollTwoFactor)
+	router.POST("/2fa/confirm", authHandler.HandleConfirmTwoFactor)
+	router.POST("/2fa/recovery_codes", authHandler.HandleRegenerateRecoveryCodes)
+	router.POST("/2fa/disable", authHandler.HandleDisableTwoFactor)
+	router.POST("/otcs", role.RequirePermission(authHandler.RoleService, role.MintOTC), authHandler.HandleMintOtc)
 }
 
 func (h *AuthHandler) HandleSignIn(c *gin.Context) {
@@ -58,6 +103,10 @@ func (h *AuthHandler) HandleSignIn(c *gin.Context) {
 	}
 
 	signInResult, err := h.Auth.CheckPassword(req.Username, req.Password)
+	if errors.Is(err, ErrUserDisabled) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -68,14 +117,148 @@ func (h *AuthHandler) HandleSignIn(c *gin.Context) {
 		return
 	}
 
-	mintedToken, err := h.Token.GenerateJWT(req.Username)
+	signedIn, err := h.UserSerivice.GetUserByUsername(c, req.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
+	// With two-factor authentication, the password only gets a challenge, which HandleSignInTwoFactor takes with a code
+	twoFactorEnabled, err := h.TwoFactor.IsEnabled(c, signedIn.UserID)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if twoFactorEnabled {
+		challenge, err := h.TwoFactor.NewChallenge(c, signedIn.UserID, req.Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge": challenge})
+		return
+	}
+	h.startSession(c, signedIn.UserID, req.Username)
+}
+
+// HandleSignInTwoFactor is the second step of signing in for users with two-factor authentication
+func (h *AuthHandler) HandleSignInTwoFactor(c *gin.Context) {
+	var req TwoFactorSignInRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": mintedToken})
+	userId, username, err := h.TwoFactor.CompleteChallenge(c, req.Challenge, req.Code)
+	if errors.Is(err, ErrInvalidChallenge) || errors.Is(err, ErrInvalidTwoFactorCode) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The account may have been disabled since the password was checked
+	disabled, err := h.UserSerivice.IsDisabled(c, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrUserDisabled.Error()})
+		return
+	}
+	h.startSession(c, userId, username)
+}
+
+// startSession signs the user in on this device
+func (h *AuthHandler) startSession(c *gin.Context, userId uuid.UUID, username string) {
+	session, refreshToken, err := h.Sessions.Create(c, userId, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.respondWithTokens(c, session, username, refreshToken)
+}
+
+// HandleRefresh swaps a refresh token for a new access token and refresh token. The old refresh token stops working.
+func (h *AuthHandler) HandleRefresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, username, refreshToken, err := h.Sessions.Refresh(c, req.RefreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.respondWithTokens(c, session, username, refreshToken)
+}
+
+// respondWithTokens sends an access token for the session, as data, along with its refresh token
+func (h *AuthHandler) respondWithTokens(c *gin.Context, session *Session, username string, refreshToken string) {
+	mintedToken, err := h.Token.GenerateJWT(session.UserID, username, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":          mintedToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(AccessTokenTTL.Seconds()),
+	})
+}
+
+// HandleGetSessions lists the caller's sessions, marking the one they are using as current
+func (h *AuthHandler) HandleGetSessions(c *gin.Context) {
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	sessions, err := h.Sessions.List(c, userId.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	currentId, _ := c.Get("session_id")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentId
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// HandleRevokeSession signs one of the caller's sessions out, which may be the current one
+func (h *AuthHandler) HandleRevokeSession(c *gin.Context) {
+	sessionId, err := uuid.Parse(c.Param("sessionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		return
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	err = h.Sessions.Revoke(c, userId.(uuid.UUID), sessionId)
+	if errors.Is(err, ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, nil)
 }
 
 func (h *AuthHandler) HandleSignUp(c *gin.Context) {
@@ -121,11 +304,164 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": "ok"})
 }
 
+// HandleMintOtc creates a signup code, the same as the CLI's otc command. The body, a CreateOtcRequest, is optional.
+// Roles the code comes with must be ones the caller could grant themselves.
+func (h *AuthHandler) HandleMintOtc(c *gin.Context) {
+	var request CreateOtcRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	createdBy := userId.(uuid.UUID)
+
+	err := role.CanGrantRoles(c, h.RoleService, request.Roles)
+	if errors.Is(err, role.ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, role.ErrRoleTooHigh) || errors.Is(err, role.ErrEscalation) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	otc, err := h.Otc.Create(c, request, &createdBy)
+	if errors.Is(err, ErrInvalidOtcRequest) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, role.ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"otc": otc.Code, "invite": otc})
+}
+
+// twoFactorStatus is the HTTP status for an error from the two-factor endpoints
+func twoFactorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidTwoFactorCode):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrTwoFactorEnabled), errors.Is(err, ErrTwoFactorNotEnabled), errors.Is(err, ErrNoEnrollment):
+		return http.StatusConflict
+	case errors.Is(err, user.ErrUserNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// HandleGetTwoFactor is whether the caller has two-factor authentication, and how many recovery codes they have left
+func (h *AuthHandler) HandleGetTwoFactor(c *gin.Context) {
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	status, err := h.TwoFactor.Status(c, userId.(uuid.UUID))
+	if err != nil {
+		c.JSON(twoFactorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// HandleEnrollTwoFactor gives the caller a secret for their authenticator app. HandleConfirmTwoFactor turns it on.
+func (h *AuthHandler) HandleEnrollTwoFactor(c *gin.Context) {
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	enrollment, err := h.TwoFactor.BeginEnrollment(c, userId.(uuid.UUID), c.GetString("username"))
+	if err != nil {
+		c.JSON(twoFactorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// HandleConfirmTwoFactor turns two-factor authentication on with a code from the caller's app, and responds with
+// their recovery codes
+func (h *AuthHandler) HandleConfirmTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	codes, err := h.TwoFactor.ConfirmEnrollment(c, userId.(uuid.UUID), req.Code)
+	if err != nil {
+		c.JSON(twoFactorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// HandleRegenerateRecoveryCodes replaces the caller's recovery codes, which takes a current code
+func (h *AuthHandler) HandleRegenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	codes, err := h.TwoFactor.RegenerateRecoveryCodes(c, userId.(uuid.UUID), req.Code)
+	if err != nil {
+		c.JSON(twoFactorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// HandleDisableTwoFactor turns two-factor authentication off, which takes a current code
+func (h *AuthHandler) HandleDisableTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	err := h.TwoFactor.Disable(c, userId.(uuid.UUID), req.Code)
+	if err != nil {
+		c.JSON(twoFactorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": "ok"})
+}
+
 func AuthMiddleware(t *TokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		path := c.FullPath()
 
-		if path == signupRoute || path == signInRoute || path == checkPasswordRoute {
+		if path == signupRoute || path == signInRoute || path == signInTwoFactorRoute || path == refreshRoute ||
+			path == checkPasswordRoute {
 			c.Next()
 			return
 		}
@@ -133,23 +469,40 @@ func AuthMiddleware(t *TokenService) gin.HandlerFunc {
 		var bearerHeader = c.GetHeader("Authorization")
 		if bearerHeader == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
+			return
 		}
 
 		var startsWithBearer = strings.HasPrefix(bearerHeader, "Bearer ")
 		if !startsWithBearer {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
+			return
 		}
 		bearerToken := strings.TrimPrefix(bearerHeader, "Bearer ")
 		claims, err := t.ValidateJWT(bearerToken)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
+			return
+		}
+
+		// Tokens outlive their session being revoked and the account being disabled, so check on every request
+		revoked, err := t.Sessions.IsRevoked(c.Request.Context(), claims.SessionID)
+		if err != nil || revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
+			return
+		}
+		disabled, err := t.UserService.IsDisabled(c.Request.Context(), claims.UserID)
+		if err != nil || disabled {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
+			return
 		}
 		c.Set("username", claims.Username)
 		c.Set("user_id", claims.UserID)
+		c.Set("session_id", claims.SessionID)
 
 		userRoles, err := t.UserService.GetUserRoles(c.Request.Context(), claims.UserID)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{})
+			return
 		}
 
 		c.Set("user_roles", userRoles)
@@ -1,22 +1,245 @@
 package auth
 
 import (
+	"backend/role"
 	"context"
+	"errors"
+	"slices"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// DefaultOtcExpiry is how long an OTC lasts unless it is created with another expiry
+const DefaultOtcExpiry = time.Hour
+
+var ErrOtcNotFound = errors.New("no OTC with that code, or it is already revoked")
+var ErrInvalidOtc = errors.New("invalid otc")
+var ErrInvalidOtcRequest = errors.New("max_uses and expires_in can't be negative")
+
 type Otc struct {
 	DB *pgxpool.Pool
 }
 
-func (o *Otc) GenerateUuid() (uuid.UUID, error) {
-	newId := uuid.New()
+// SignupOtc is an invite to sign up. It can be used MaxUses times before it expires, and grants Roles along with
+// default to everyone who signs up with it.
+type SignupOtc struct {
+	Code    uuid.UUID `json:"code"`
+	Label   string    `json:"label,omitempty"`
+	MaxUses int       `json:"max_uses"`
+	Uses    int       `json:"uses"`
+	Roles   []string  `json:"roles"`
+	// CreatedBy is nil for OTCs minted through the CLI
+	CreatedBy   *uuid.UUID `json:"created_by"`
+	TimeCreated time.Time  `json:"time_created"`
+	// TimeExpires is nil for OTCs that never expire
+	TimeExpires *time.Time `json:"time_expires"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	// Redemptions is only filled in by Get
+	Redemptions []Redemption `json:"redemptions,omitempty"`
+}
+
+// Redemption is someone signing up with an OTC
+type Redemption struct {
+	UserID       uuid.UUID `json:"user_id"`
+	Username     string    `json:"username"`
+	TimeRedeemed time.Time `json:"time_redeemed"`
+}
+
+type CreateOtcRequest struct {
+	Label string `json:"label"`
+	// MaxUses defaults to 1
+	MaxUses int `json:"max_uses"`
+	// ExpiresIn is in seconds, and defaults to DefaultOtcExpiry. 0 never expires.
+	ExpiresIn *int     `json:"expires_in"`
+	Roles     []string `json:"roles"`
+}
+
+// State is whether the OTC can still be used, or why not: active, revoked, used up or expired
+func (o SignupOtc) State(now time.Time) string {
+	switch {
+	case o.RevokedAt != nil:
+		return "revoked"
+	case o.Uses >= o.MaxUses:
+		return "used up"
+	case o.TimeExpires != nil && !now.Before(*o.TimeExpires):
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+// Create mints an OTC. createdBy is the user minting it, or nil for the CLI.
+func (o *Otc) Create(ctx context.Context, request CreateOtcRequest, createdBy *uuid.UUID) (*SignupOtc, error) {
+	if request.MaxUses < 0 || (request.ExpiresIn != nil && *request.ExpiresIn < 0) {
+		return nil, ErrInvalidOtcRequest
+	}
+	otc := SignupOtc{
+		Code:      uuid.New(),
+		Label:     request.Label,
+		MaxUses:   max(request.MaxUses, 1),
+		Roles:     []string{},
+		CreatedBy: createdBy,
+	}
+	expiresIn := int(DefaultOtcExpiry.Seconds())
+	if request.ExpiresIn != nil {
+		expiresIn = *request.ExpiresIn
+	}
+
+	tx, err := o.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// A null interval leaves time_expires null, which never expires
+	err = tx.QueryRow(ctx,
+		`insert into open_discord.signup_otcs (code, label, max_uses, time_expires, created_by)
+		values ($1, nullif($2::text, ''), $3, now() + nullif($4::int, 0) * interval '1 second', $5)
+		returning time_created, time_expires`,
+		otc.Code, otc.Label, otc.MaxUses, expiresIn, createdBy,
+	).Scan(&otc.TimeCreated, &otc.TimeExpires)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, roleName := range request.Roles {
+		if slices.Contains(otc.Roles, roleName) {
+			continue
+		}
+		tag, err := tx.Exec(ctx,
+			`insert into open_discord.signup_otc_roles (code, role_id)
+			select $1, id from open_discord.roles where name = $2`,
+			otc.Code, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if tag.RowsAffected() == 0 {
+			return nil, role.ErrRoleNotFound
+		}
+		otc.Roles = append(otc.Roles, roleName)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &otc, nil
+}
+
+const otcColumns = `o.code, coalesce(o.label, ''), o.max_uses, o.uses, o.created_by, o.time_created, o.time_expires,
+	o.revoked_at, array(
+		select r.name from open_discord.signup_otc_roles sr
+		join open_discord.roles r on r.id = sr.role_id
+		where sr.code = o.code
+		order by r.position desc
+	)`
+
+func scanOtc(row pgx.Row) (SignupOtc, error) {
+	var otc SignupOtc
+	err := row.Scan(&otc.Code, &otc.Label, &otc.MaxUses, &otc.Uses, &otc.CreatedBy, &otc.TimeCreated,
+		&otc.TimeExpires, &otc.RevokedAt, &otc.Roles)
+	return otc, err
+}
+
+// List returns every OTC, newest first
+func (o *Otc) List(ctx context.Context) ([]SignupOtc, error) {
+	rows, err := o.DB.Query(ctx,
+		`select `+otcColumns+` from open_discord.signup_otcs o order by o.time_created desc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	otcs := []SignupOtc{}
+	for rows.Next() {
+		otc, err := scanOtc(rows)
+		if err != nil {
+			return nil, err
+		}
+		otcs = append(otcs, otc)
+	}
+	return otcs, rows.Err()
+}
+
+// Get returns the OTC along with who signed up with it
+func (o *Otc) Get(ctx context.Context, code uuid.UUID) (*SignupOtc, error) {
+	otc, err := scanOtc(o.DB.QueryRow(ctx,
+		`select `+otcColumns+` from open_discord.signup_otcs o where o.code = $1`, code))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOtcNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := o.DB.Query(ctx,
+		`select rd.user_id, u.username, rd.time_redeemed
+		from open_discord.signup_otc_redemptions rd
+		join open_discord.users u on u.id = rd.user_id
+		where rd.code = $1
+		order by rd.time_redeemed`,
+		code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	otc.Redemptions = []Redemption{}
+	for rows.Next() {
+		var redemption Redemption
+		err = rows.Scan(&redemption.UserID, &redemption.Username, &redemption.TimeRedeemed)
+		if err != nil {
+			return nil, err
+		}
+		otc.Redemptions = append(otc.Redemptions, redemption)
+	}
+	return &otc, rows.Err()
+}
+
+// Revoke stops an OTC from being used any more. It is kept, along with its redemptions, for auditing.
+func (o *Otc) Revoke(ctx context.Context, code uuid.UUID) error {
+	tag, err := o.DB.Exec(ctx,
+		`update open_discord.signup_otcs set revoked_at = now() where code = $1 and revoked_at is null`, code)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOtcNotFound
+	}
+	return nil
+}
+
+// redeem uses up one use of the OTC for the new user, and gives them its roles. It is part of the signup transaction,
+// so a failed signup doesn't use the OTC.
+func redeem(ctx context.Context, tx pgx.Tx, code, userId uuid.UUID) error {
+	// Checking and counting the use in one statement keeps concurrent signups from going over max_uses
+	tag, err := tx.Exec(ctx,
+		`update open_discord.signup_otcs
+		set uses = uses + 1
+		where code = $1
+		and revoked_at is null
+		and uses < max_uses
+		and (time_expires is null or time_expires > now())`,
+		code)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidOtc
+	}
 
-	_, err := o.DB.Exec(context.Background(), `insert into open_discord.signup_otcs(code) values ($1)`, newId)
+	_, err = tx.Exec(ctx,
+		`insert into open_discord.signup_otc_redemptions (code, user_id) values ($1, $2)`, code, userId)
 	if err != nil {
-		return uuid.Nil, err
+		return err
 	}
-	return newId, nil
+	_, err = tx.Exec(ctx,
+		`insert into open_discord.user_roles (user_id, role_id)
+		select $2, role_id from open_discord.signup_otc_roles where code = $1
+		on conflict do nothing`,
+		code, userId)
+	return err
 }
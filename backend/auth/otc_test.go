@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignupOtcState(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		otc  SignupOtc
+		want string
+	}{
+		{"unused", SignupOtc{MaxUses: 1, TimeExpires: &later}, "active"},
+		{"never expires", SignupOtc{MaxUses: 5, Uses: 4}, "active"},
+		{"used up", SignupOtc{MaxUses: 5, Uses: 5, TimeExpires: &later}, "used up"},
+		{"expired", SignupOtc{MaxUses: 1, TimeExpires: &earlier}, "expired"},
+		{"revoked", SignupOtc{MaxUses: 1, Uses: 1, TimeExpires: &earlier, RevokedAt: &earlier}, "revoked"},
+	}
+	for _, test := range tests {
+		if got := test.otc.State(now); got != test.want {
+			t.Errorf("%v: State() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var ErrUserDisabled = errors.New("account is disabled")
+
 type Service struct {
 	DB *pgxpool.Pool
 }
@@ -40,25 +42,11 @@ func (a *Service) Signup(username string, password string, otc uuid.UUID) error
 		return errors.New("password does not meet strength requirements")
 	}
 
-	// OTC validation
 	passwordHash, err := a.HashPassword(password)
 	if err != nil {
 		return err
 	}
 
-	var otcExists bool
-	a.DB.QueryRow(context.Background(),
-		`select exists(
-		select * from open_discord.signup_otcs o
-			 where o.code = $1
-			 and o.used = false
-			 and o.time_expires > now())
-			 `, otc).Scan(&otcExists)
-
-	if !otcExists {
-		return errors.New("invalid otc")
-	}
-
 	slog.Info("User passed validation checks, inserting them into database",
 		slog.String("username", username),
 	)
@@ -78,13 +66,6 @@ func (a *Service) Signup(username string, password string, otc uuid.UUID) error
 		return err
 	}
 
-	// Mark the OTC as used
-	_, err = tx.Exec(context.Background(), `update open_discord.signup_otcs o set used = true where o.code = $1`, otc)
-	if err != nil {
-		slog.Error(err.Error())
-		return err
-	}
-
 	slog.Info("Assigning user to default role",
 		slog.String("username", username),
 	)
@@ -102,6 +83,12 @@ func (a *Service) Signup(username string, password string, otc uuid.UUID) error
 		fmt.Print(err.Error())
 		return err
 	}
+
+	// Use the OTC, which also gives the user any roles it comes with
+	err = redeem(context.Background(), tx, otc, userId)
+	if err != nil {
+		return err
+	}
 	tx.Commit(context.Background())
 	return nil
 }
@@ -159,14 +146,15 @@ func CheckPasswordStrength(password string) CheckPasswordResult {
 }
 
 // CheckPassword get the existing password from the DB and use its salt to hash the provided password
-// and check if they match.
+// and check if they match. A correct password for a disabled account returns ErrUserDisabled.
 func (a *Service) CheckPassword(username, password string) (bool, error) {
 	var existingPassword string
+	var disabled bool
 
 	row := a.DB.QueryRow(context.Background(),
-		`select u.password from open_discord.users u where u.username = $1`, username)
+		`select u.password, u.disabled_at is not null from open_discord.users u where u.username = $1`, username)
 
-	err := row.Scan(&existingPassword)
+	err := row.Scan(&existingPassword, &disabled)
 	if err != nil {
 		return false, err
 	}
@@ -175,6 +163,9 @@ func (a *Service) CheckPassword(username, password string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	if result && disabled {
+		return false, ErrUserDisabled
+	}
 
 	return result, nil
 }
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"backend/logic"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// RefreshTokenTTL is how long a session lasts without being used. Every refresh starts it over.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// refreshGracePeriod is how long the refresh token a session was just rotated away from is refused without revoking
+// the session, since another tab may have been refreshing with it at the same time
+const refreshGracePeriod = 30 * time.Second
+
+var ErrSessionNotFound = errors.New("session not found")
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// Session is a signed in device
+type Session struct {
+	ID           uuid.UUID `json:"id"`
+	UserID       uuid.UUID `json:"user_id"`
+	UserAgent    string    `json:"user_agent"`
+	IP           string    `json:"ip"`
+	TimeCreated  time.Time `json:"time_created"`
+	TimeLastUsed time.Time `json:"time_last_used"`
+	TimeExpires  time.Time `json:"time_expires"`
+	// Current is whether this is the session of the request listing them
+	Current bool `json:"current"`
+}
+
+type SessionService struct {
+	DB             *pgxpool.Pool
+	RedisClient    *redis.Client
+	ClientRegistry *logic.ClientRegistry
+}
+
+func NewSessionService(db *pgxpool.Pool, redisClient *redis.Client, clientRegistry *logic.ClientRegistry) *SessionService {
+	return &SessionService{
+		DB:             db,
+		RedisClient:    redisClient,
+		ClientRegistry: clientRegistry,
+	}
+}
+
+// newRandomToken returns a random token for a client to hold on to, such as a refresh token
+func newRandomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// hashRefreshToken is what is stored for a refresh token, so a leaked database can't be used to refresh
+func hashRefreshToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// Create starts a session for a user who has just signed in, returning it along with its first refresh token
+func (s *SessionService) Create(ctx context.Context, userId uuid.UUID, userAgent, ip string) (*Session, string, error) {
+	refreshToken, err := newRandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := Session{UserID: userId, UserAgent: userAgent, IP: ip}
+	err = s.DB.QueryRow(ctx,
+		`insert into open_discord.sessions (user_id, refresh_token_hash, user_agent, ip, time_expires)
+		values ($1, $2, $3, $4, now() + $5 * interval '1 second')
+		returning id, time_created, time_last_used, time_expires`,
+		userId, hashRefreshToken(refreshToken), userAgent, ip, int(RefreshTokenTTL.Seconds()),
+	).Scan(&session.ID, &session.TimeCreated, &session.TimeLastUsed, &session.TimeExpires)
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, refreshToken, nil
+}
+
+// Refresh swaps a refresh token for a new one, returning the session and the username it belongs to. A refresh token
+// that was already swapped means it was copied, so the session is revoked.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (*Session, string, string, error) {
+	hash := hashRefreshToken(refreshToken)
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var session Session
+	var username string
+	var current bool
+	var timeRotated time.Time
+	err = tx.QueryRow(ctx,
+		`select s.id, s.user_id, u.username, s.refresh_token_hash = $1, s.time_rotated
+		from open_discord.sessions s
+		join open_discord.users u on u.id = s.user_id
+		where (s.refresh_token_hash = $1 or s.previous_refresh_token_hash = $1)
+		and s.revoked_at is null
+		and s.time_expires > now()
+		and u.disabled_at is null
+		for update of s`,
+		hash,
+	).Scan(&session.ID, &session.UserID, &username, &current, &timeRotated)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if !current {
+		if time.Since(timeRotated) < refreshGracePeriod {
+			return nil, "", "", ErrInvalidRefreshToken
+		}
+		slog.Warn("Revoking session whose refresh token was reused", slog.String("session_id", session.ID.String()))
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, "", "", err
+		}
+		err = s.Revoke(ctx, session.UserID, session.ID)
+		if err != nil {
+			return nil, "", "", err
+		}
+		return nil, "", "", ErrInvalidRefreshToken
+	}
+
+	newToken, err := newRandomToken()
+	if err != nil {
+		return nil, "", "", err
+	}
+	err = tx.QueryRow(ctx,
+		`update open_discord.sessions
+		set previous_refresh_token_hash = refresh_token_hash,
+			refresh_token_hash = $2,
+			time_rotated = now(),
+			time_last_used = now(),
+			time_expires = now() + $3 * interval '1 second'
+		where id = $1
+		returning user_agent, ip, time_created, time_last_used, time_expires`,
+		session.ID, hashRefreshToken(newToken), int(RefreshTokenTTL.Seconds()),
+	).Scan(&session.UserAgent, &session.IP, &session.TimeCreated, &session.TimeLastUsed, &session.TimeExpires)
+	if err != nil {
+		return nil, "", "", err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return &session, username, newToken, nil
+}
+
+// List returns the user's sessions that haven't been revoked or expired, most recently used first
+func (s *SessionService) List(ctx context.Context, userId uuid.UUID) ([]Session, error) {
+	rows, err := s.DB.Query(ctx,
+		`select id, user_id, user_agent, ip, time_created, time_last_used, time_expires
+		from open_discord.sessions
+		where user_id = $1 and revoked_at is null and time_expires > now()
+		order by time_last_used desc`,
+		userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err = rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &session.TimeCreated,
+			&session.TimeLastUsed, &session.TimeExpires)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func sessionRevokedRedisKey(sessionId uuid.UUID) string {
+	return "session_revoked:" + sessionId.String()
+}
+
+// Revoke ends one of the user's sessions. Its access tokens stop working and its open connections are closed.
+func (s *SessionService) Revoke(ctx context.Context, userId, sessionId uuid.UUID) error {
+	tag, err := s.DB.Exec(ctx,
+		`update open_discord.sessions set revoked_at = now() where id = $1 and user_id = $2 and revoked_at is null`,
+		sessionId, userId)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	// Overwrite rather than delete the cached state, so a check racing with this can't cache the session as active
+	err = s.RedisClient.Set(ctx, sessionRevokedRedisKey(sessionId), "1", AccessTokenTTL).Err()
+	if err != nil {
+		slog.Error("Error caching revoked session", slog.String("session_id", sessionId.String()))
+	}
+	s.ClientRegistry.DisconnectSession(userId, sessionId)
+	return nil
+}
+
+// IsRevoked reports whether the session has been revoked. It is checked on every request, so it is cached.
+func (s *SessionService) IsRevoked(ctx context.Context, sessionId uuid.UUID) (bool, error) {
+	redisKey := sessionRevokedRedisKey(sessionId)
+	cached, err := s.RedisClient.Get(ctx, redisKey).Result()
+	if err == nil {
+		return cached == "1", nil
+	}
+
+	var revoked bool
+	err = s.DB.QueryRow(ctx,
+		`select revoked_at is not null from open_discord.sessions where id = $1`, sessionId).Scan(&revoked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Sessions are deleted along with their user
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	value := "0"
+	if revoked {
+		value = "1"
+	}
+	// Access tokens don't outlive AccessTokenTTL, so neither does what is cached about their session
+	err = s.RedisClient.SetNX(ctx, redisKey, value, AccessTokenTTL).Err()
+	if err != nil {
+		slog.Error("Error caching whether session is revoked", slog.String("session_id", sessionId.String()))
+	}
+	return revoked, nil
+}
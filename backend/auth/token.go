@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -15,25 +14,30 @@ import (
 type TokenService struct {
 	Secret      []byte
 	UserService *user.UserService
+	Sessions    *SessionService
 }
 
+// AccessTokenTTL is how long an access token lasts. Clients get a new one from their session's refresh token.
+const AccessTokenTTL = 15 * time.Minute
+
+var ErrNoSession = errors.New("token has no session")
+
 type Claims struct {
 	UserID   uuid.UUID `json:"id"`
 	Username string    `json:"username"`
+	// SessionID is the session the token was issued to. The token stops working when the session is revoked.
+	SessionID uuid.UUID `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func (t *TokenService) GenerateJWT(username string) (string, error) {
-	user, err := t.UserService.GetUserByUsername(context.Background(), username)
-	if err != nil {
-		return "", err
-	}
-
+// GenerateJWT issues an access token for the user's session
+func (t *TokenService) GenerateJWT(userId uuid.UUID, username string, sessionId uuid.UUID) (string, error) {
 	claims := Claims{
-		Username: username,
-		UserID:   user.UserID,
+		Username:  username,
+		UserID:    userId,
+		SessionID: sessionId,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "open_disc",
@@ -68,5 +72,9 @@ func (t *TokenService) ValidateJWT(tokenStr string) (*Claims, error) {
 	if !ok || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	// Tokens issued before sessions existed can't be revoked, so they aren't accepted
+	if claims.SessionID == uuid.Nil {
+		return nil, ErrNoSession
+	}
 	return claims, nil
 }
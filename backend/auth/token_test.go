@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestJWTRoundTrip(t *testing.T) {
+	tokens := &TokenService{Secret: []byte("secret")}
+	userId := uuid.New()
+	sessionId := uuid.New()
+
+	signed, err := tokens.GenerateJWT(userId, "lee", sessionId)
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+	claims, err := tokens.ValidateJWT(signed)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if claims.UserID != userId || claims.Username != "lee" || claims.SessionID != sessionId {
+		t.Errorf("ValidateJWT() = %+v", claims)
+	}
+	if lifetime := claims.ExpiresAt.Sub(claims.IssuedAt.Time); lifetime != AccessTokenTTL {
+		t.Errorf("token lasts %v, want %v", lifetime, AccessTokenTTL)
+	}
+}
+
+func TestValidateJWTRequiresSession(t *testing.T) {
+	tokens := &TokenService{Secret: []byte("secret")}
+
+	// A token from before sessions, which has no sid
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		UserID:   uuid.New(),
+		Username: "lee",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+		},
+	})
+	signed, err := legacy.SignedString(tokens.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.ValidateJWT(signed); !errors.Is(err, ErrNoSession) {
+		t.Errorf("ValidateJWT() error = %v, want %v", err, ErrNoSession)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	first, err := newRandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := newRandomToken()
+	if first == second || len(first) != 43 {
+		t.Errorf("newRandomToken() = %q then %q, want 43 random characters each", first, second)
+	}
+	if string(hashRefreshToken(first)) == string(hashRefreshToken(second)) {
+		t.Error("different refresh tokens should hash differently")
+	}
+}
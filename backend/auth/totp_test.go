@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// rfcSecret is the SHA-1 secret used by the test vectors in RFC 4226 and RFC 6238
+var rfcSecret = []byte("12345678901234567890")
+
+func TestHotpMatchesRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		if got := hotp(rfcSecret, uint64(counter), 6); got != code {
+			t.Errorf("hotp(%v) = %v, want %v", counter, got, code)
+		}
+	}
+}
+
+func TestTotpMatchesRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, test := range tests {
+		step := totpStep(time.Unix(test.unix, 0))
+		if got := hotp(rfcSecret, uint64(step), 8); got != test.want {
+			t.Errorf("TOTP at %v = %v, want %v", test.unix, got, test.want)
+		}
+	}
+}
+
+func TestMatchTotpAllowsOneStepOfSkew(t *testing.T) {
+	// The code for time step 1, the same as the RFC's 94287082 cut down to 6 digits
+	const code = "287082"
+	issued := time.Unix(59, 0)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		ok   bool
+	}{
+		{"same step", issued, true},
+		{"phone behind by a step", issued.Add(totpPeriod), true},
+		{"phone ahead by a step", time.Unix(0, 0), true},
+		{"two steps late", issued.Add(2 * totpPeriod), false},
+	}
+	for _, test := range tests {
+		step, ok := matchTotp(rfcSecret, code, test.now)
+		if ok != test.ok {
+			t.Errorf("%v: matchTotp() ok = %v, want %v", test.name, ok, test.ok)
+		}
+		if ok && step != 1 {
+			t.Errorf("%v: matchTotp() step = %v, want 1", test.name, step)
+		}
+	}
+
+	if _, ok := matchTotp(rfcSecret, "94287082", issued); ok {
+		t.Error("an 8 digit code should not match")
+	}
+}
+
+func TestTotpURI(t *testing.T) {
+	uri, err := url.Parse(totpURI("open_discord", "lee smith", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/open_discord:lee smith" {
+		t.Errorf("totpURI() = %v", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || query.Get("issuer") != "open_discord" {
+		t.Errorf("totpURI() query = %v", query)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %v codes and %v hashes, want %v", len(codes), len(hashes), recoveryCodeCount)
+	}
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q should look like abcde-fghjk", code)
+		}
+		if isTotpCode(normalizeCode(code)) {
+			t.Errorf("code %q should not be mistaken for an app code", code)
+		}
+		if string(hashRecoveryCode(normalizeCode(code))) != string(hashes[i]) {
+			t.Errorf("code %q doesn't match its hash", code)
+		}
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := map[string]string{
+		"123 456":     "123456",
+		"ABCDE-FGHJK": "abcdefghjk",
+		"oLI1e-abcde": "0111eabcde",
+	}
+	for code, want := range tests {
+		if got := normalizeCode(code); got != want {
+			t.Errorf("normalizeCode(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestChallengeAttemptsAreLimited(t *testing.T) {
+	redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+	twoFactor := NewTwoFactorService(nil, redisClient)
+	ctx := context.Background()
+
+	userId := uuid.New()
+	challenge, err := twoFactor.NewChallenge(ctx, userId, "lee")
+	if err != nil {
+		t.Fatalf("NewChallenge() error = %v", err)
+	}
+	if ttl := redisClient.TTL(ctx, twoFactorChallengeRedisKey(challenge)).Val(); ttl <= 0 || ttl > twoFactorChallengeTTL {
+		t.Errorf("challenge expires in %v, want %v", ttl, twoFactorChallengeTTL)
+	}
+
+	for range maxTwoFactorAttempts {
+		gotId, gotUsername, err := twoFactor.takeChallenge(ctx, challenge)
+		if err != nil {
+			t.Fatalf("takeChallenge() error = %v", err)
+		}
+		if gotId != userId || gotUsername != "lee" {
+			t.Errorf("takeChallenge() = %v, %v", gotId, gotUsername)
+		}
+	}
+	for range 2 {
+		if _, _, err := twoFactor.takeChallenge(ctx, challenge); !errors.Is(err, ErrInvalidChallenge) {
+			t.Errorf("takeChallenge() after too many attempts error = %v, want %v", err, ErrInvalidChallenge)
+		}
+	}
+	if _, _, err := twoFactor.takeChallenge(ctx, "made up"); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("takeChallenge() of an unknown challenge error = %v, want %v", err, ErrInvalidChallenge)
+	}
+}
@@ -0,0 +1,368 @@
+package auth
+
+import (
+	"backend/user"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// twoFactorChallengeTTL is how long a user has to enter their code after their password
+const twoFactorChallengeTTL = 5 * time.Minute
+
+// maxTwoFactorAttempts is how many codes can be tried for each time the password is entered
+const maxTwoFactorAttempts = 5
+
+var ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+var ErrInvalidChallenge = errors.New("two-factor sign in expired, sign in again")
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication isn't enabled")
+var ErrNoEnrollment = errors.New("start enrolling in two-factor authentication first")
+
+// takeChallenge counts an attempt at a challenge, returning the user and username it is for. Once the attempts are
+// used up the challenge is deleted, so guessing codes means entering the password again.
+var takeChallenge = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'user_id', 'username')
+if not fields[1] then
+	return false
+end
+if redis.call('HINCRBY', KEYS[1], 'attempts', 1) > tonumber(ARGV[1]) then
+	redis.call('DEL', KEYS[1])
+	return false
+end
+return fields
+`)
+
+type TwoFactorService struct {
+	DB          *pgxpool.Pool
+	RedisClient *redis.Client
+	// Issuer is what authenticator apps show the account as belonging to
+	Issuer string
+	// Now is the clock codes are checked against
+	Now func() time.Time
+}
+
+func NewTwoFactorService(db *pgxpool.Pool, redisClient *redis.Client) *TwoFactorService {
+	return &TwoFactorService{
+		DB:          db,
+		RedisClient: redisClient,
+		Issuer:      "open_discord",
+		Now:         time.Now,
+	}
+}
+
+// TwoFactorStatus is whether a user has two-factor authentication, and how many recovery codes they have left
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// TotpEnrollment is what a user adds to their authenticator app, either by scanning URI as a QR code or by typing in
+// Secret
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+func (s *TwoFactorService) Status(ctx context.Context, userId uuid.UUID) (*TwoFactorStatus, error) {
+	var status TwoFactorStatus
+	err := s.DB.QueryRow(ctx,
+		`select u.totp_enabled_at is not null, (
+			select count(*) from open_discord.recovery_codes r where r.user_id = u.id and r.used_at is null
+		)
+		from open_discord.users u where u.id = $1`,
+		userId,
+	).Scan(&status.Enabled, &status.RecoveryCodesLeft)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, user.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (s *TwoFactorService) IsEnabled(ctx context.Context, userId uuid.UUID) (bool, error) {
+	status, err := s.Status(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	return status.Enabled, nil
+}
+
+// BeginEnrollment gives the user a new secret for their authenticator app. Two-factor authentication isn't enabled
+// until ConfirmEnrollment is called with a code from the app, so a secret that didn't make it into the app can't lock
+// them out.
+func (s *TwoFactorService) BeginEnrollment(ctx context.Context, userId uuid.UUID, username string) (*TotpEnrollment, error) {
+	secret, err := newTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+	tag, err := s.DB.Exec(ctx,
+		`update open_discord.users set totp_secret = $2 where id = $1 and totp_enabled_at is null`, userId, secret)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrTwoFactorEnabled
+	}
+	return &TotpEnrollment{
+		Secret: totpEncoding.EncodeToString(secret),
+		URI:    totpURI(s.Issuer, username, secret),
+	}, nil
+}
+
+// ConfirmEnrollment enables two-factor authentication once the user shows their app makes the right codes, and
+// returns their recovery codes. They are only ever shown here.
+func (s *TwoFactorService) ConfirmEnrollment(ctx context.Context, userId uuid.UUID, code string) ([]string, error) {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var secret []byte
+	var enabled bool
+	err = tx.QueryRow(ctx,
+		`select totp_secret, totp_enabled_at is not null from open_discord.users where id = $1 for update`,
+		userId,
+	).Scan(&secret, &enabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, user.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if secret == nil {
+		return nil, ErrNoEnrollment
+	}
+
+	step, ok := matchTotp(secret, normalizeCode(code), s.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	_, err = tx.Exec(ctx,
+		`update open_discord.users set totp_enabled_at = now(), totp_last_step = $2 where id = $1`, userId, step)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks a code from the user's authenticator app, or one of their recovery codes, which is then used up.
+// Each app code only works once.
+func (s *TwoFactorService) Verify(ctx context.Context, userId uuid.UUID, code string) error {
+	var secret []byte
+	var enabled bool
+	err := s.DB.QueryRow(ctx,
+		`select totp_secret, totp_enabled_at is not null from open_discord.users where id = $1`, userId,
+	).Scan(&secret, &enabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	code = normalizeCode(code)
+	if isTotpCode(code) {
+		step, ok := matchTotp(secret, code, s.Now())
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		// Moving the last step forward in the same statement that checks it keeps a code from being used twice
+		tag, err := s.DB.Exec(ctx,
+			`update open_discord.users set totp_last_step = $2 where id = $1 and totp_last_step < $2`, userId, step)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	tag, err := s.DB.Exec(ctx,
+		`update open_discord.recovery_codes set used_at = now()
+		where user_id = $1 and code_hash = $2 and used_at is null`,
+		userId, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, after checking a code the same as Verify
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userId uuid.UUID, code string) ([]string, error) {
+	err := s.Verify(ctx, userId, code)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	codes, err := replaceRecoveryCodes(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off, after checking a code the same as Verify
+func (s *TwoFactorService) Disable(ctx context.Context, userId uuid.UUID, code string) error {
+	err := s.Verify(ctx, userId, code)
+	if err != nil {
+		return err
+	}
+	return s.clear(ctx, userId)
+}
+
+// Reset turns a user's two-factor authentication off without a code, for when they have lost their app and their
+// recovery codes
+func (s *TwoFactorService) Reset(ctx context.Context, username string) error {
+	var userId uuid.UUID
+	var enabled bool
+	err := s.DB.QueryRow(ctx,
+		`select id, totp_enabled_at is not null from open_discord.users where username = $1`, username,
+	).Scan(&userId, &enabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTwoFactorNotEnabled
+	}
+	return s.clear(ctx, userId)
+}
+
+func (s *TwoFactorService) clear(ctx context.Context, userId uuid.UUID) error {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`update open_discord.users set totp_secret = null, totp_enabled_at = null, totp_last_step = 0 where id = $1`,
+		userId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `delete from open_discord.recovery_codes where user_id = $1`, userId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userId uuid.UUID) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `delete from open_discord.recovery_codes where user_id = $1`, userId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx,
+		`insert into open_discord.recovery_codes (user_id, code_hash) select $1, unnest($2::bytea[])`, userId, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func twoFactorChallengeRedisKey(challenge string) string {
+	return "two_factor_challenge:" + challenge
+}
+
+// NewChallenge is handed out in place of tokens when a user with two-factor authentication gets their password right.
+// They swap it, along with a code, for tokens.
+func (s *TwoFactorService) NewChallenge(ctx context.Context, userId uuid.UUID, username string) (string, error) {
+	challenge, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+	redisKey := twoFactorChallengeRedisKey(challenge)
+	_, err = s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisKey, "user_id", userId.String(), "username", username, "attempts", 0)
+		pipe.Expire(ctx, redisKey, twoFactorChallengeTTL)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// CompleteChallenge checks the code for a challenge, returning the user and username it was for. A challenge only
+// works once.
+func (s *TwoFactorService) CompleteChallenge(ctx context.Context, challenge string, code string) (uuid.UUID, string, error) {
+	userId, username, err := s.takeChallenge(ctx, challenge)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	err = s.Verify(ctx, userId, code)
+	if errors.Is(err, ErrTwoFactorNotEnabled) {
+		// Two-factor authentication was turned off after the password was entered, so the password alone is enough
+		// now, but only a fresh sign in can say so
+		return uuid.Nil, "", ErrInvalidChallenge
+	}
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	err = s.RedisClient.Del(ctx, twoFactorChallengeRedisKey(challenge)).Err()
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return userId, username, nil
+}
+
+func (s *TwoFactorService) takeChallenge(ctx context.Context, challenge string) (uuid.UUID, string, error) {
+	fields, err := takeChallenge.Run(ctx, s.RedisClient,
+		[]string{twoFactorChallengeRedisKey(challenge)}, maxTwoFactorAttempts).StringSlice()
+	if errors.Is(err, redis.Nil) {
+		return uuid.Nil, "", ErrInvalidChallenge
+	}
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	userId, err := uuid.Parse(fields[0])
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return userId, fields[1], nil
+}
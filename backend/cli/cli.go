@@ -7,9 +7,14 @@ import (
 	"backend/user"
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"sync"
+
+	"golang.org/x/term"
 )
 
 type Cli struct {
@@ -17,77 +22,152 @@ type Cli struct {
 	RoleService *role.Service
 	UserService *user.UserService
 	RoomService *room.RoomService
+	// RoomHandler creates and deletes rooms, since that also updates connections and sends events
+	RoomHandler *room.RoomHandler
+	TwoFactor   *auth.TwoFactorService
+	output      *switchWriter
 }
 
-func NewCli(otc *auth.Otc, roleService *role.Service, userService *user.UserService, roomService *room.RoomService) *Cli {
+func NewCli(
+	otc *auth.Otc,
+	roleService *role.Service,
+	userService *user.UserService,
+	roomService *room.RoomService,
+	roomHandler *room.RoomHandler,
+	twoFactor *auth.TwoFactorService,
+) *Cli {
 	return &Cli{
 		Otc:         otc,
 		RoleService: roleService,
 		UserService: userService,
 		RoomService: roomService,
+		RoomHandler: roomHandler,
+		TwoFactor:   twoFactor,
+		output:      &switchWriter{w: os.Stdout},
 	}
 }
 
+// Result is what a command did: Message for people and Data, if the command returns anything, for scripts
+type Result struct {
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// UsageError is returned for a command that is unknown or called with the wrong arguments
+type UsageError struct {
+	Usage string
+}
+
+func (e *UsageError) Error() string {
+	return "usage: " + e.Usage
+}
+
+func usage(text string) error {
+	return &UsageError{Usage: text}
+}
+
+// switchWriter writes to stdout, or to the terminal while the CLI has it, so logs don't scribble over the prompt
+type switchWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func (s *switchWriter) set(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w = w
+}
+
+// Output is where the rest of the server should write its logs while the CLI is running
+func (c *Cli) Output() io.Writer {
+	return c.output
+}
+
+// Run reads commands from stdin until it is closed, printing what each of them did. On a terminal the input can be
+// edited and tab completed.
 func (c *Cli) Run() {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		c.runLines()
+		return
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		c.runLines()
+		return
+	}
+
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "-> ")
+	terminal.AutoCompleteCallback = c.autoComplete
+	c.output.set(terminal)
+	fmt.Fprintln(terminal, "Waiting for input, try help")
+
+	for {
+		line, err := terminal.ReadLine()
+		if err != nil {
+			c.output.set(os.Stdout)
+			term.Restore(fd, state)
+			// Raw mode turns Ctrl-C into input, so pass it on as the interrupt it would otherwise have been. Ctrl-D
+			// arrives the same way and is treated alike.
+			if errors.Is(err, io.EOF) {
+				if process, err := os.FindProcess(os.Getpid()); err == nil {
+					process.Signal(os.Interrupt)
+				}
+			}
+			return
+		}
+		c.runLine(terminal, line)
+	}
+}
+
+// runLines reads commands line by line, for when stdin isn't a terminal
+func (c *Cli) runLines() {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Waiting for input")
 	fmt.Println("---------------")
 
 	for {
 		fmt.Print("-> ")
-		userText, _ := reader.ReadString('\n')
-		text := strings.Replace(userText, "\r\n", "\n", -1)
-		text = strings.ReplaceAll(text, "\n", "")
-
-		commandParams := strings.Split(text, " ")
-
-		switch commandParams[0] {
-		case "otc":
-			otc, err := c.Otc.GenerateUuid()
-			if err != nil {
-				fmt.Printf("Error generating OTC: %v\n", err)
-				continue
-			}
-			fmt.Println(otc)
-		case "role":
-			c.HandleRoleCommand(commandParams)
-			continue
-		case "ur":
-			c.HandleUserRoleCOmmands(commandParams)
-			continue
-		case "assignroomrole":
-			if len(commandParams) < 3 {
-				fmt.Println("Usage: assignroomrole <room_name> <role_name>")
-				continue
-			}
-			roomName := commandParams[1]
-			roleName := commandParams[2]
-			err := c.RoomService.AssignRoomRole(context.Background(), roomName, roleName)
-			if err != nil {
-				fmt.Printf("Error assigning room role: %v\n", err)
-				continue
-			}
-			fmt.Printf("Assigned role %v to room %v\n", roleName, roomName)
-		case "removeroomrole":
-			if len(commandParams) < 3 {
-				fmt.Println("Usage: removeroomrole <room_name> <role_name>")
-				continue
-			}
-			roomName := commandParams[1]
-			roleName := commandParams[2]
-			err := c.RoomService.RemoveRoomRole(context.Background(), roomName, roleName)
-			if err != nil {
-				fmt.Printf("Error removing room role: %v\n", err)
-				continue
-			}
-			fmt.Printf("Removed role %v from room %v\n", roleName, roomName)
-		default:
-			fmt.Println("Unknown command")
-			fmt.Println("Available commands: otc")
-			fmt.Println("---------------")
-			fmt.Print("-> ")
-			continue
+		userText, err := reader.ReadString('\n')
+		c.runLine(os.Stdout, strings.TrimRight(userText, "\r\n"))
+		if err != nil {
+			// stdin is closed when the server runs detached, which leaves the admin socket
+			fmt.Println()
+			return
 		}
+	}
+}
+
+func (c *Cli) runLine(w io.Writer, line string) {
+	args, err := Split(line)
+	if err != nil {
+		fmt.Fprintf(w, "Error: %v\n", err)
+		return
+	}
+	if len(args) == 0 {
+		return
+	}
+	result, err := c.Execute(context.Background(), args)
+	c.print(w, result, err)
+}
 
+func (c *Cli) print(w io.Writer, result *Result, err error) {
+	var usageError *UsageError
+	switch {
+	case errors.As(err, &usageError):
+		fmt.Fprintln(w, "Usage: "+usageError.Usage)
+	case err != nil:
+		fmt.Fprintf(w, "Error: %v\n", err)
+	default:
+		fmt.Fprintln(w, result.Message)
 	}
 }
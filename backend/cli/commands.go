@@ -0,0 +1,264 @@
+package cli
+
+import (
+	"backend/role"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// errBadArguments is returned by a command's run when its arguments don't parse, and becomes a UsageError for it
+var errBadArguments = errors.New("bad arguments")
+
+// nameKind is what kind of name an argument is, so tab completion can offer the existing ones
+type nameKind int
+
+const (
+	noName nameKind = iota
+	roleName
+	userName
+	roomName
+)
+
+type command struct {
+	name    string
+	aliases []string
+	// args is the usage of the command's arguments, e.g. "<role_name> <position>"
+	args string
+	help string
+	// minArgs and maxArgs bound the number of arguments. A maxArgs of -1 is unlimited.
+	minArgs, maxArgs int
+	// names is what kind of name each argument is, and flagNames what kind of name follows a flag
+	names       []nameKind
+	flagNames   map[string]nameKind
+	run         func(c *Cli, ctx context.Context, args []string) (*Result, error)
+	subcommands []*command
+}
+
+// commands is set in init, since help refers back to it
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name: "help", args: "[command [subcommand]]", maxArgs: 2,
+			help: "Shows what a command does. Commands also take --help.",
+			run:  (*Cli).help,
+		},
+		{
+			name: "otc", args: "[--count <n>] [--uses <n>] [--expires <duration>|never] [--label <label>] [--role <role_name>]...",
+			maxArgs: -1, flagNames: map[string]nameKind{"--role": roleName},
+			help: fmt.Sprintf("Mints OTCs for signing up, from 1 to %v at a time. Each can be used --uses times, 1 by "+
+				"default, and expires after --expires, such as 30m, 12h or 7d, or an hour by default. Everyone who signs "+
+				"up with one gets its --role roles besides default.", maxOtcCount),
+			run: (*Cli).mintOtcs,
+			subcommands: []*command{
+				{name: "ls", aliases: []string{"list"}, help: "Lists OTCs, newest first.", run: (*Cli).listOtcs},
+				{
+					name: "show", args: "<code>", minArgs: 1, maxArgs: 1,
+					help: "Shows an OTC along with who signed up with it.",
+					run:  (*Cli).showOtc,
+				},
+				{
+					name: "revoke", args: "<code>", minArgs: 1, maxArgs: 1,
+					help: "Stops an OTC from being used. It is still listed, along with who signed up with it.",
+					run:  (*Cli).revokeOtc,
+				},
+			},
+		},
+		{
+			name: "role",
+			help: "Manages roles.",
+			subcommands: []*command{
+				{
+					name: "make", args: "<role_name>", minArgs: 1, maxArgs: 1,
+					help: "Creates a role with no permissions.",
+					run:  (*Cli).makeRole,
+				},
+				{
+					name: "delete", args: "<role_name>", minArgs: 1, maxArgs: 1, names: []nameKind{roleName},
+					help: "Deletes a role. Roles that are still assigned to users can't be deleted.",
+					run:  (*Cli).deleteRole,
+				},
+				{
+					name: "perms", args: "<role_name> [permission,...]", minArgs: 1, maxArgs: 2, names: []nameKind{roleName},
+					help: "Sets a role's permissions, or clears them without a list. Permissions are " +
+						strings.Join(role.Permission(-1).Names(), ", ") + ".",
+					run: (*Cli).setRolePermissions,
+				},
+				{
+					name: "pos", args: "<role_name> <position>", minArgs: 2, maxArgs: 2, names: []nameKind{roleName},
+					help: "Sets a role's position, a whole number of at least 0. Roles can only be managed by higher ones.",
+					run:  (*Cli).setRolePosition,
+				},
+				{name: "ls", aliases: []string{"list"}, help: "Lists roles, highest first.", run: (*Cli).listRoles},
+			},
+		},
+		{
+			name: "ur",
+			help: "Manages which roles users have.",
+			subcommands: []*command{
+				{
+					name: "assign", args: "<username> <role_name>", minArgs: 2, maxArgs: 2,
+					names: []nameKind{userName, roleName},
+					help:  "Gives a user a role.",
+					run:   (*Cli).assignUserRole,
+				},
+				{
+					name: "remove", args: "<username> <role_name>", minArgs: 2, maxArgs: 2,
+					names: []nameKind{userName, roleName},
+					help:  "Takes a role away from a user.",
+					run:   (*Cli).removeUserRole,
+				},
+				{
+					name: "ls", aliases: []string{"list"}, args: "<username>", minArgs: 1, maxArgs: 1,
+					names: []nameKind{userName},
+					help:  "Lists a user's roles.",
+					run:   (*Cli).listUserRoles,
+				},
+			},
+		},
+		{
+			name: "user",
+			help: "Manages users.",
+			subcommands: []*command{
+				{name: "ls", aliases: []string{"list"}, help: "Lists users.", run: (*Cli).listUsers},
+				{
+					name: "disable", args: "<username>", minArgs: 1, maxArgs: 1, names: []nameKind{userName},
+					help: "Stops a user from signing in and disconnects them. Their tokens stop working.",
+					run:  (*Cli).disableUser,
+				},
+				{
+					name: "enable", args: "<username>", minArgs: 1, maxArgs: 1, names: []nameKind{userName},
+					help: "Lets a disabled user sign in again.",
+					run:  (*Cli).enableUser,
+				},
+				{
+					name: "reset-2fa", args: "<username>", minArgs: 1, maxArgs: 1, names: []nameKind{userName},
+					help: "Turns off a user's two-factor authentication, for when they have lost their app and recovery codes.",
+					run:  (*Cli).resetTwoFactor,
+				},
+			},
+		},
+		{
+			name: "room",
+			help: "Manages rooms.",
+			subcommands: []*command{
+				{
+					name: "create", args: "<room_name>", minArgs: 1, maxArgs: 1,
+					help: "Creates a room that the default role can see.",
+					run:  (*Cli).createRoom,
+				},
+				{
+					name: "delete", args: "<room_name>", minArgs: 1, maxArgs: 1, names: []nameKind{roomName},
+					help: "Deletes a room along with its messages.",
+					run:  (*Cli).deleteRoom,
+				},
+				{name: "ls", aliases: []string{"list"}, help: "Lists rooms, not including DMs.", run: (*Cli).listRooms},
+			},
+		},
+		{
+			name: "assignroomrole", args: "<room_name> <role_name> [allow] [deny]", minArgs: 2, maxArgs: 4,
+			names: []nameKind{roomName, roleName},
+			help: "Sets what a role is allowed and denied in a room, replacing what it had. Permissions are comma " +
+				"separated from view, post, react and attach, or - for none. Without them the role is allowed everything.",
+			run: (*Cli).assignRoomRole,
+		},
+		{
+			name: "removeroomrole", args: "<room_name> <role_name>", minArgs: 2, maxArgs: 2,
+			names: []nameKind{roomName, roleName},
+			help:  "Removes a role's overrides from a room.",
+			run:   (*Cli).removeRoomRole,
+		},
+	}
+}
+
+func findCommand(list []*command, name string) *command {
+	for _, cmd := range list {
+		if cmd.name == name || slices.Contains(cmd.aliases, name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func commandNames(list []*command) []string {
+	names := make([]string, len(list))
+	for i, cmd := range list {
+		names[i] = cmd.name
+	}
+	return names
+}
+
+// resolve finds the command args are for, returning it along with its full name and its arguments
+func resolve(args []string) (cmd *command, path string, rest []string) {
+	cmd = findCommand(commands, args[0])
+	if cmd == nil {
+		return nil, "", nil
+	}
+	path = cmd.name
+	rest = args[1:]
+	if len(rest) > 0 {
+		if sub := findCommand(cmd.subcommands, rest[0]); sub != nil {
+			return sub, path + " " + sub.name, rest[1:]
+		}
+	}
+	return cmd, path, rest
+}
+
+func (cmd *command) usage(path string) string {
+	if cmd.run == nil {
+		return path + " " + strings.Join(commandNames(cmd.subcommands), "|") + " ..."
+	}
+	return strings.TrimSpace(path + " " + cmd.args)
+}
+
+// describe is the help for a command, followed by that of its subcommands
+func (cmd *command) describe(path string) string {
+	lines := []string{cmd.usage(path), "    " + cmd.help}
+	for _, sub := range cmd.subcommands {
+		lines = append(lines, sub.usage(path+" "+sub.name), "    "+sub.help)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Execute runs a command, such as []string{"ur", "assign", "lee", "admin"}, and returns what it did
+func (c *Cli) Execute(ctx context.Context, args []string) (*Result, error) {
+	topLevel := "commands are " + strings.Join(commandNames(commands), ", ") + "; try help <command>"
+	if len(args) == 0 {
+		return nil, usage("<command> [arguments]; " + topLevel)
+	}
+	cmd, path, rest := resolve(args)
+	if cmd == nil {
+		return nil, usage("unknown command " + args[0] + "; " + topLevel)
+	}
+	if slices.Contains(rest, "--help") {
+		return &Result{Message: cmd.describe(path)}, nil
+	}
+	if cmd.run == nil || len(rest) < cmd.minArgs || (cmd.maxArgs >= 0 && len(rest) > cmd.maxArgs) {
+		return nil, usage(cmd.usage(path))
+	}
+
+	result, err := cmd.run(c, ctx, rest)
+	if errors.Is(err, errBadArguments) {
+		return nil, usage(cmd.usage(path) + "; see help " + path)
+	}
+	return result, err
+}
+
+func (c *Cli) help(_ context.Context, args []string) (*Result, error) {
+	if len(args) == 0 {
+		lines := make([]string, 0, len(commands))
+		for _, cmd := range commands {
+			lines = append(lines, fmt.Sprintf("%-45v %v", cmd.usage(cmd.name), cmd.help))
+		}
+		return &Result{Message: strings.Join(lines, "\n")}, nil
+	}
+	cmd, path, rest := resolve(args)
+	if cmd == nil || len(rest) > 0 {
+		return nil, usage("help [command [subcommand]]; commands are " + strings.Join(commandNames(commands), ", "))
+	}
+	return &Result{Message: cmd.describe(path)}, nil
+}
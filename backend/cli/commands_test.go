@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExecuteChecksArguments(t *testing.T) {
+	const otcUsage = "otc [--count <n>] [--uses <n>] [--expires <duration>|never] [--label <label>] [--role <role_name>]..."
+	cli := &Cli{}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"role", "fly"}, "role make|delete|perms|pos|ls ..."},
+		{[]string{"role", "make"}, "role make <role_name>"},
+		{[]string{"ur", "assign", "lee", "admin", "extra"}, "ur assign <username> <role_name>"},
+		{[]string{"role", "pos", "admin", "high"}, "role pos <role_name> <position>; see help role pos"},
+		{[]string{"otc", "revoke", "nope"}, "otc revoke <code>; see help otc revoke"},
+		{[]string{"otc", "--uses", "0"}, otcUsage + "; see help otc"},
+		{[]string{"otc", "--expires", "soon"}, otcUsage + "; see help otc"},
+		{[]string{"otc", "--label"}, otcUsage + "; see help otc"},
+	}
+	for _, test := range tests {
+		_, err := cli.Execute(context.Background(), test.args)
+		var usageError *UsageError
+		if !errors.As(err, &usageError) || usageError.Usage != test.want {
+			t.Errorf("Execute(%q) error = %v, want usage %q", test.args, err, test.want)
+		}
+	}
+}
+
+func TestExecuteHelp(t *testing.T) {
+	cli := &Cli{}
+	result, err := cli.Execute(context.Background(), []string{"help", "room"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	for _, want := range []string{"room create <room_name>", "room delete <room_name>", "room ls"} {
+		if !strings.Contains(result.Message, want) {
+			t.Errorf("help room = %q, want it to include %q", result.Message, want)
+		}
+	}
+
+	// --help works anywhere, even where the arguments are wrong, and aliases are shown by their name
+	result, err = cli.Execute(context.Background(), []string{"user", "list", "--help"})
+	if err != nil || !strings.HasPrefix(result.Message, "user ls\n") {
+		t.Errorf("Execute() = %v, %v, want help for user ls", result, err)
+	}
+}
+
+func TestCompleteLine(t *testing.T) {
+	names := func(kind nameKind) []string {
+		switch kind {
+		case roleName:
+			return []string{"admin", "moderator"}
+		case roomName:
+			return []string{"General Chat", "General Talk", "random"}
+		}
+		return nil
+	}
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{"ro", "ro", false},
+		{"rol", "role ", true},
+		{"role d", "role delete ", true},
+		{"role delete m", "role delete moderator ", true},
+		{"assignroomrole Gen", `assignroomrole "General "`, true},
+		{`assignroomrole "General C`, `assignroomrole "General Chat" `, true},
+		{`assignroomrole "General Chat" a`, `assignroomrole "General Chat" admin `, true},
+		{"role make a", "", false},
+		{"otc --uses 5 --role mod", "otc --uses 5 --role moderator ", true},
+		{"nope ", "", false},
+	}
+	for _, test := range tests {
+		got, ok := completeLine(test.line, names)
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("completeLine(%q) = %q, %v, want %q, %v", test.line, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	tests := map[string]int{"never": 0, "90m": 5400, "7d": 7 * 24 * 60 * 60}
+	for value, want := range tests {
+		if got, err := parseExpiry(value); err != nil || got != want {
+			t.Errorf("parseExpiry(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"soon", "0s", "-1d"} {
+		if _, err := parseExpiry(value); err == nil {
+			t.Errorf("parseExpiry(%q) should fail", value)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// autoComplete is the terminal's tab completion. It completes command names, and role, user and room names where a
+// command takes them.
+func (c *Cli) autoComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head, ok := completeLine(line[:pos], c.names)
+	if !ok {
+		return "", 0, false
+	}
+	return head + line[pos:], len(head), true
+}
+
+// names returns the existing names of a kind, or none if they can't be fetched
+func (c *Cli) names(kind nameKind) []string {
+	ctx := context.Background()
+	var names []string
+	switch kind {
+	case roleName:
+		roles, _ := c.RoleService.GetAllRoles()
+		for _, role := range roles {
+			names = append(names, role.Name)
+		}
+	case userName:
+		users, _ := c.UserService.GetAllUsers(ctx)
+		for _, user := range users {
+			names = append(names, user.Username)
+		}
+	case roomName:
+		rooms, _ := c.rooms(ctx)
+		for _, room := range rooms {
+			names = append(names, room.Name)
+		}
+	}
+	return names
+}
+
+// completeLine completes the last argument of line, which is everything before the cursor. A single match is
+// completed and quoted if need be, while several are completed as far as they agree.
+func completeLine(line string, names func(nameKind) []string) (string, bool) {
+	args, partial, _ := split(line)
+	prefix := ""
+	if partial >= 0 {
+		prefix = args[len(args)-1]
+		args = args[:len(args)-1]
+	} else {
+		partial = len(line)
+	}
+
+	var candidates []string
+	switch {
+	case len(args) == 0:
+		candidates = commandNames(commands)
+	default:
+		cmd, _, rest := resolve(args)
+		switch {
+		case cmd == nil:
+		case len(rest) == 0 && len(cmd.subcommands) > 0 && len(args) == 1:
+			candidates = commandNames(cmd.subcommands)
+		case len(rest) > 0 && cmd.flagNames[rest[len(rest)-1]] != noName:
+			candidates = names(cmd.flagNames[rest[len(rest)-1]])
+		case len(rest) < len(cmd.names):
+			candidates = names(cmd.names[len(rest)])
+		}
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+	if len(matches) == 1 {
+		return line[:partial] + Quote(matches[0]) + " ", true
+	}
+
+	common := slices.Min(matches)
+	for _, match := range matches {
+		for !strings.HasPrefix(match, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	for !utf8.ValidString(common) {
+		common = common[:len(common)-1]
+	}
+	if common == prefix {
+		return "", false
+	}
+	return line[:partial] + Quote(common), true
+}
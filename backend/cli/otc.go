@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"backend/auth"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxOtcCount caps otc --count so a typo can't mint millions of codes
+const maxOtcCount = 100
+
+const otcTimeFormat = "2006-01-02 15:04"
+
+func (c *Cli) mintOtcs(ctx context.Context, args []string) (*Result, error) {
+	count := 1
+	var request auth.CreateOtcRequest
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return nil, errBadArguments
+		}
+		flag, value := args[0], args[1]
+		args = args[2:]
+
+		var err error
+		switch flag {
+		case "--count":
+			count, err = strconv.Atoi(value)
+			if err == nil && (count < 1 || count > maxOtcCount) {
+				err = errBadArguments
+			}
+		case "--uses":
+			request.MaxUses, err = strconv.Atoi(value)
+			if err == nil && request.MaxUses < 1 {
+				err = errBadArguments
+			}
+		case "--expires":
+			var seconds int
+			seconds, err = parseExpiry(value)
+			request.ExpiresIn = &seconds
+		case "--label":
+			request.Label = value
+		case "--role":
+			request.Roles = append(request.Roles, value)
+		default:
+			err = errBadArguments
+		}
+		if err != nil {
+			return nil, errBadArguments
+		}
+	}
+
+	otcs := make([]*auth.SignupOtc, 0, count)
+	lines := make([]string, 0, count)
+	for range count {
+		otc, err := c.Otc.Create(ctx, request, nil)
+		if err != nil {
+			return nil, fmt.Errorf("generating OTC: %w", err)
+		}
+		otcs = append(otcs, otc)
+		lines = append(lines, otc.Code.String())
+	}
+	return &Result{Message: strings.Join(lines, "\n"), Data: otcs}, nil
+}
+
+// parseExpiry parses how long an OTC lasts, in seconds, as a Go duration, a number of days such as 7d, or never, which
+// is 0
+func parseExpiry(value string) (int, error) {
+	if value == "never" {
+		return 0, nil
+	}
+	var duration time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		duration = time.Duration(count) * 24 * time.Hour
+	} else {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if duration < time.Second {
+		return 0, errBadArguments
+	}
+	return int(duration.Seconds()), nil
+}
+
+// describeOtc is one line about the OTC: its code, label, uses, roles and whether it can still be used
+func describeOtc(otc auth.SignupOtc, now time.Time) string {
+	line := otc.Code.String()
+	if otc.Label != "" {
+		line += " " + Quote(otc.Label)
+	}
+	line += fmt.Sprintf(" created %v, used %v/%v", otc.TimeCreated.Format(otcTimeFormat), otc.Uses, otc.MaxUses)
+	if len(otc.Roles) > 0 {
+		line += fmt.Sprintf(", grants %v", otc.Roles)
+	}
+	state := otc.State(now)
+	if state == "active" && otc.TimeExpires != nil {
+		state = "expires " + otc.TimeExpires.Format(otcTimeFormat)
+	}
+	return line + ", " + state
+}
+
+func (c *Cli) listOtcs(ctx context.Context, _ []string) (*Result, error) {
+	otcs, err := c.Otc.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing OTCs: %w", err)
+	}
+	now := time.Now()
+	lines := make([]string, len(otcs))
+	for i, otc := range otcs {
+		lines[i] = describeOtc(otc, now)
+	}
+	return &Result{Message: strings.Join(lines, "\n"), Data: otcs}, nil
+}
+
+func (c *Cli) showOtc(ctx context.Context, args []string) (*Result, error) {
+	code, err := uuid.Parse(args[0])
+	if err != nil {
+		return nil, errBadArguments
+	}
+	otc, err := c.Otc.Get(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("showing OTC: %w", err)
+	}
+	lines := []string{describeOtc(*otc, time.Now())}
+	for _, redemption := range otc.Redemptions {
+		lines = append(lines, fmt.Sprintf("- %v signed up %v", redemption.Username, redemption.TimeRedeemed.Format(otcTimeFormat)))
+	}
+	return &Result{Message: strings.Join(lines, "\n"), Data: otc}, nil
+}
+
+func (c *Cli) revokeOtc(ctx context.Context, args []string) (*Result, error) {
+	code, err := uuid.Parse(args[0])
+	if err != nil {
+		return nil, errBadArguments
+	}
+	err = c.Otc.Revoke(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("revoking OTC: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Revoked OTC %v", code)}, nil
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var ErrUnterminatedQuote = errors.New("unterminated quote")
+
+// Split splits a command line into arguments the way a shell would, so names with spaces can be quoted:
+// room create "General Chat". Single quotes keep everything inside them as is, and a backslash escapes the next
+// character outside of single quotes.
+func Split(line string) ([]string, error) {
+	args, _, err := split(line)
+	return args, err
+}
+
+// split is Split that also returns the byte offset the last argument starts at if the line ends partway through it,
+// or -1 if the line ends between arguments. This is the argument tab completion completes.
+func split(line string) (args []string, partial int, err error) {
+	var current strings.Builder
+	var quote rune
+	inArg := false
+	escaped := false
+	partial = -1
+
+	for i, r := range line {
+		if !inArg && !unicode.IsSpace(r) {
+			inArg = true
+			partial = i
+		}
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+				partial = -1
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if escaped {
+		// A trailing backslash has nothing to escape, so it is kept
+		current.WriteRune('\\')
+	}
+	if quote != 0 {
+		err = ErrUnterminatedQuote
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, partial, err
+}
+
+// Quote quotes arg, if it needs it, so Split reads it back as one argument
+func Quote(arg string) string {
+	if arg != "" && !strings.ContainsFunc(arg, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '"' || r == '\'' || r == '\\'
+	}) {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
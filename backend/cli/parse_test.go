@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"  role   ls ", []string{"role", "ls"}},
+		{`room create "General Chat"`, []string{"room", "create", "General Chat"}},
+		{`room create 'it''s "quoted"'`, []string{"room", "create", `its "quoted"`}},
+		{`room create General\ Chat`, []string{"room", "create", "General Chat"}},
+		{`room create "say \"hi\""`, []string{"room", "create", `say "hi"`}},
+		{`ur assign "" admin`, []string{"ur", "assign", "", "admin"}},
+		{`role make back\`, []string{"role", "make", `back\`}},
+		{"", nil},
+	}
+	for _, test := range tests {
+		got, err := Split(test.line)
+		if err != nil {
+			t.Errorf("Split(%q) error = %v", test.line, err)
+			continue
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("Split(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+
+	if _, err := Split(`room create "General`); !errors.Is(err, ErrUnterminatedQuote) {
+		t.Errorf("Split() error = %v, want %v", err, ErrUnterminatedQuote)
+	}
+}
+
+func TestQuoteRoundTrips(t *testing.T) {
+	for _, arg := range []string{"admin", "General Chat", `say "hi"`, `it's`, `back\slash`, ""} {
+		got, err := Split(Quote(arg))
+		if err != nil || len(got) != 1 || got[0] != arg {
+			t.Errorf("Split(Quote(%q)) = %q, %v", arg, got, err)
+		}
+	}
+	if got := Quote("admin"); got != "admin" {
+		t.Errorf("Quote() = %v, want it unquoted", got)
+	}
+}
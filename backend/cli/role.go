@@ -1,43 +1,66 @@
 package cli
 
-import "fmt"
+import (
+	"backend/role"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
-func (c *Cli) HandleRoleCommand(commandParams []string) {
-	// At this point we know the first command was "role"
+func (c *Cli) makeRole(_ context.Context, args []string) (*Result, error) {
+	created, err := c.RoleService.CreateRole(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("creating role: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Created role %v", created.Name), Data: created}, nil
+}
+
+func (c *Cli) deleteRole(_ context.Context, args []string) (*Result, error) {
+	err := c.RoleService.DeleteRole(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("deleting role: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Deleted role %v", args[0])}, nil
+}
+
+func (c *Cli) setRolePermissions(ctx context.Context, args []string) (*Result, error) {
+	// Without a list the role is left with no permissions
+	var names []string
+	if len(args) > 1 {
+		names = strings.Split(args[1], ",")
+	}
+	permissions, err := role.ParsePermissions(names)
+	if err != nil {
+		return nil, fmt.Errorf("setting role permissions: %w", err)
+	}
+	updated, err := c.RoleService.SetPermissions(ctx, args[0], permissions)
+	if err != nil {
+		return nil, fmt.Errorf("setting role permissions: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Role %v now has %v", updated.Name, updated.Permissions.Names()), Data: updated}, nil
+}
 
-	switch commandParams[1] {
-	case "make":
-		if len(commandParams) < 3 {
-			fmt.Println("Usage: make role <role_name>")
-			return
-		}
-		roleName := commandParams[2]
-		role, err := c.RoleService.CreateRole(roleName)
-		if err != nil {
-			fmt.Printf("Error creating role: %v\n", err)
+func (c *Cli) setRolePosition(ctx context.Context, args []string) (*Result, error) {
+	position, err := strconv.Atoi(args[1])
+	if err != nil || position < 0 {
+		return nil, errBadArguments
+	}
+	updated, err := c.RoleService.Update(ctx, args[0], role.UpdateRoleRequest{Position: &position})
+	if err != nil {
+		return nil, fmt.Errorf("setting role position: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Role %v is now at position %v", updated.Name, updated.Position), Data: updated}, nil
+}
 
-		}
-		fmt.Printf("Created role: %v\n", role)
-	case "delete":
-		if len(commandParams) < 3 {
-			fmt.Println("Usage: deleterole <role_name>")
-			return
-		}
-		roleName := commandParams[2]
-		err := c.RoleService.DeleteRole(roleName)
-		if err != nil {
-			fmt.Printf("Error deleting role: %v\n", err)
-			return
-		}
-		fmt.Printf("Deleted role: %v\n", roleName)
-	case "ls", "list":
-		roles, err := c.RoleService.GetAllRoles()
-		if err != nil {
-			fmt.Printf("Error listing roles: %v\n", err)
-			return
-		}
-		for _, role := range roles {
-			fmt.Printf("Role: %v\n", role)
-		}
+func (c *Cli) listRoles(_ context.Context, _ []string) (*Result, error) {
+	roles, err := c.RoleService.GetAllRoles()
+	if err != nil {
+		return nil, fmt.Errorf("listing roles: %w", err)
+	}
+	lines := make([]string, len(roles))
+	for i, role := range roles {
+		lines[i] = fmt.Sprintf("Role: %v (position %v) %v", Quote(role.Name), role.Position, role.Permissions.Names())
 	}
+	return &Result{Message: strings.Join(lines, "\n"), Data: roles}, nil
 }
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"backend/role"
+	"backend/room"
+	"context"
+	"fmt"
+	"strings"
+)
+
+func (c *Cli) createRoom(ctx context.Context, args []string) (*Result, error) {
+	created, err := c.RoomHandler.CreateRoom(ctx, room.CreateRoomRequest{Name: args[0]})
+	if err != nil {
+		return nil, fmt.Errorf("creating room: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Created room %v", created.Name), Data: created}, nil
+}
+
+func (c *Cli) deleteRoom(ctx context.Context, args []string) (*Result, error) {
+	found, err := c.RoomService.GetByName(ctx, args[0])
+	if err != nil {
+		return nil, fmt.Errorf("deleting room: %w", err)
+	}
+	deleted, err := c.RoomHandler.DeleteRoom(ctx, found.ID)
+	if err != nil {
+		return nil, fmt.Errorf("deleting room: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Deleted room %v", deleted.Name), Data: deleted}, nil
+}
+
+func (c *Cli) listRooms(ctx context.Context, _ []string) (*Result, error) {
+	rooms, err := c.rooms(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing rooms: %w", err)
+	}
+	lines := make([]string, len(rooms))
+	for i, room := range rooms {
+		lines[i] = fmt.Sprintf("Room: %v (%v)", Quote(room.Name), room.ID)
+	}
+	return &Result{Message: strings.Join(lines, "\n"), Data: rooms}, nil
+}
+
+// rooms returns every room, but not DMs, in their sort order
+func (c *Cli) rooms(ctx context.Context) ([]room.Room, error) {
+	all, err := c.RoomService.GetAll(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	rooms := []room.Room{}
+	for _, r := range all {
+		if r.Kind == room.KindRoom {
+			rooms = append(rooms, r)
+		}
+	}
+	return rooms, nil
+}
+
+func (c *Cli) assignRoomRole(ctx context.Context, args []string) (*Result, error) {
+	roomName := args[0]
+	roleName := args[1]
+	allow := role.AllRoomPermissions
+	var deny role.RoomPermission
+	var err error
+	if len(args) > 2 {
+		allow, err = parseRoomPermissions(args[2])
+	}
+	if err == nil && len(args) > 3 {
+		deny, err = parseRoomPermissions(args[3])
+	}
+	if err == nil {
+		err = c.RoomService.AssignRoomRole(ctx, roomName, roleName, allow, deny)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("assigning room role: %w", err)
+	}
+	return &Result{
+		Message: fmt.Sprintf("Role %v in room %v now allows %v and denies %v", roleName, roomName, allow.Names(), deny.Names()),
+		Data:    room.RoomRole{Name: roleName, Allow: allow, Deny: deny},
+	}, nil
+}
+
+func (c *Cli) removeRoomRole(ctx context.Context, args []string) (*Result, error) {
+	roomName := args[0]
+	roleName := args[1]
+	err := c.RoomService.RemoveRoomRole(ctx, roomName, roleName)
+	if err != nil {
+		return nil, fmt.Errorf("removing room role: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Removed role %v from room %v", roleName, roomName)}, nil
+}
+
+// parseRoomPermissions parses a comma separated list of room permissions, where "-" is the empty set
+func parseRoomPermissions(list string) (role.RoomPermission, error) {
+	if list == "-" {
+		return 0, nil
+	}
+	return role.ParseRoomPermissions(strings.Split(list, ","))
+}
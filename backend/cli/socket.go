@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// DefaultSocketPath is where the admin socket is created unless ADMIN_SOCKET says otherwise
+const DefaultSocketPath = "admin.sock"
+
+const socketTimeout = 30 * time.Second
+
+var ErrSocketInUse = errors.New("admin socket is in use by another server")
+
+// socketRequest and socketResponse are sent as one JSON line each way over the admin socket
+type socketRequest struct {
+	Args []string `json:"args"`
+}
+
+type socketResponse struct {
+	Result *Result `json:"result,omitempty"`
+	Error  string  `json:"error,omitempty"`
+	// Usage is set instead of Error for a UsageError
+	Usage string `json:"usage,omitempty"`
+}
+
+// Serve runs commands sent to a Unix socket at path until ctx is cancelled. The socket is only accessible to the user
+// running the server, which is what authorizes its clients.
+func (c *Cli) Serve(ctx context.Context, path string) error {
+	// A socket left behind by a server that didn't shut down cleanly is removed, but not one that is still answering
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return ErrSocketInUse
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go c.serveConn(ctx, conn)
+	}
+}
+
+func (c *Cli) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+
+	var request socketRequest
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &request)
+	}
+	var response socketResponse
+	if err != nil {
+		response.Error = "invalid request: " + err.Error()
+	} else {
+		slog.Info("Running admin socket command", slog.Any("args", request.Args))
+		result, err := c.Execute(ctx, request.Args)
+		var usageError *UsageError
+		switch {
+		case errors.As(err, &usageError):
+			response.Usage = usageError.Usage
+		case err != nil:
+			response.Error = err.Error()
+		default:
+			response.Result = result
+		}
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		slog.Warn("Failed to answer admin socket command", slog.String("error", err.Error()))
+	}
+}
+
+// Call sends a command to the admin socket at path and returns what it did
+func Call(path string, args []string) (*Result, error) {
+	conn, err := net.DialTimeout("unix", path, socketTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the admin socket, is the server running? %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+
+	if err := json.NewEncoder(conn).Encode(socketRequest{Args: args}); err != nil {
+		return nil, err
+	}
+	var response socketResponse
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	switch {
+	case response.Usage != "":
+		return nil, &UsageError{Usage: response.Usage}
+	case response.Error != "":
+		return nil, errors.New(response.Error)
+	}
+	return response.Result, nil
+}
+
+// RunAdmin is the admin subcommand, e.g. `backend admin otc --count 5`. It runs a command on the server through the
+// admin socket, printing what it did, or with --json the Result, and returns the exit code.
+func RunAdmin(args []string, socketPath string, stdout, stderr io.Writer) int {
+	asJson := false
+	for len(args) > 0 {
+		if args[0] == "--json" {
+			asJson = true
+			args = args[1:]
+		} else if args[0] == "--socket" && len(args) > 1 {
+			socketPath = args[1]
+			args = args[2:]
+		} else {
+			break
+		}
+	}
+
+	result, err := Call(socketPath, args)
+	var usageError *UsageError
+	if asJson {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		switch {
+		case errors.As(err, &usageError):
+			encoder.Encode(socketResponse{Usage: usageError.Usage})
+			return 2
+		case err != nil:
+			encoder.Encode(socketResponse{Error: err.Error()})
+			return 1
+		}
+		encoder.Encode(result)
+		return 0
+	}
+
+	switch {
+	case errors.As(err, &usageError):
+		fmt.Fprintln(stderr, "Usage: backend admin [--json] [--socket <path>] "+usageError.Usage)
+		return 2
+	case err != nil:
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, result.Message)
+	return 0
+}
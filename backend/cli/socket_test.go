@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveTestSocket serves a Cli without services, which is enough for commands that fail before reaching them
+func serveTestSocket(t *testing.T) (*Cli, string) {
+	t.Helper()
+	cli := &Cli{}
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go cli.Serve(ctx, path)
+
+	for range 100 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return cli, path
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("admin socket never came up")
+	return nil, ""
+}
+
+func TestCallReturnsUsageErrors(t *testing.T) {
+	_, path := serveTestSocket(t)
+
+	const roleUsage = "role make|delete|perms|pos|ls ..."
+	_, err := Call(path, []string{"role"})
+	var usageError *UsageError
+	if !errors.As(err, &usageError) || usageError.Usage != roleUsage {
+		t.Errorf("Call() error = %v, want usage %q", err, roleUsage)
+	}
+
+	_, err = Call(path, []string{"otc", "--count", "1000"})
+	if !errors.As(err, &usageError) {
+		t.Errorf("Call() error = %v, want a usage error", err)
+	}
+}
+
+func TestRunAdminPrintsJson(t *testing.T) {
+	_, path := serveTestSocket(t)
+
+	var stdout, stderr bytes.Buffer
+	code := RunAdmin([]string{"--json", "--socket", path, "nope"}, "unused.sock", &stdout, &stderr)
+	if code != 2 {
+		t.Errorf("RunAdmin() = %v, want 2", code)
+	}
+	var response socketResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil || response.Usage == "" {
+		t.Errorf("RunAdmin() printed %q, want JSON with usage", stdout.String())
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("RunAdmin() wrote %q to stderr", stderr.String())
+	}
+}
+
+func TestServeRefusesSocketInUse(t *testing.T) {
+	cli, path := serveTestSocket(t)
+	if err := cli.Serve(context.Background(), path); !errors.Is(err, ErrSocketInUse) {
+		t.Errorf("Serve() error = %v, want %v", err, ErrSocketInUse)
+	}
+}
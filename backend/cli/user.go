@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func (c *Cli) listUsers(ctx context.Context, _ []string) (*Result, error) {
+	users, err := c.UserService.GetAllUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing users: %w", err)
+	}
+	lines := make([]string, len(users))
+	for i, user := range users {
+		line := fmt.Sprintf("User: %v (%v) %v", Quote(user.Username), user.Nickname, user.Roles)
+		if user.Disabled {
+			line += " disabled"
+		} else if user.IsOnline {
+			line += " online"
+		}
+		lines[i] = line
+	}
+	return &Result{Message: strings.Join(lines, "\n"), Data: users}, nil
+}
+
+func (c *Cli) disableUser(ctx context.Context, args []string) (*Result, error) {
+	err := c.UserService.SetDisabled(ctx, args[0], true)
+	if err != nil {
+		return nil, fmt.Errorf("disabling user: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Disabled user %v", args[0])}, nil
+}
+
+func (c *Cli) enableUser(ctx context.Context, args []string) (*Result, error) {
+	err := c.UserService.SetDisabled(ctx, args[0], false)
+	if err != nil {
+		return nil, fmt.Errorf("enabling user: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Enabled user %v", args[0])}, nil
+}
+
+func (c *Cli) resetTwoFactor(ctx context.Context, args []string) (*Result, error) {
+	err := c.TwoFactor.Reset(ctx, args[0])
+	if err != nil {
+		return nil, fmt.Errorf("resetting two-factor authentication: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Turned off two-factor authentication for user %v", args[0])}, nil
+}
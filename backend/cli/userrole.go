@@ -3,52 +3,36 @@ package cli
 import (
 	"context"
 	"fmt"
+	"strings"
 )
 
-func (c *Cli) HandleUserRoleCOmmands(commandParams []string) {
-	// At this point we know the first command was "ur"
+func (c *Cli) assignUserRole(ctx context.Context, args []string) (*Result, error) {
+	err := c.UserService.AssignUserToRole(ctx, args[0], args[1])
+	if err != nil {
+		return nil, fmt.Errorf("assigning user to role: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Assigned user %v to role %v", args[0], args[1])}, nil
+}
 
-	switch commandParams[1] {
-	case "assign":
-		if len(commandParams) < 4 {
-			fmt.Println("Usage: assignuserrole <username> <role_name>")
-			return
-		}
-		username := commandParams[2]
-		roleName := commandParams[3]
-		err := c.UserService.AssignUserToRole(context.Background(), username, roleName)
-		if err != nil {
-			fmt.Printf("Error assigning user to role: %v\n", err)
-			return
-		}
-		fmt.Printf("Assigned user %v to role %v\n", username, roleName)
-	case "remove":
-		if len(commandParams) < 4 {
-			fmt.Println("Usage: removeuserrole <username> <role_name>")
-			return
-		}
-		username := commandParams[2]
-		roleName := commandParams[3]
-		err := c.UserService.RemoveUserFromRole(context.Background(), username, roleName)
-		if err != nil {
-			fmt.Printf("Error removing user from role: %v\n", err)
-			return
-		}
-		fmt.Printf("Removed user %v from role %v\n", username, roleName)
-	case "ls", "list":
-		if len(commandParams) < 3 {
-			fmt.Println("Usage: listuserroles <username>")
-			return
-		}
-		username := commandParams[2]
-		roles, err := c.UserService.GetUserRolesByUsername(context.Background(), username)
-		if err != nil {
-			fmt.Printf("Error listing user roles: %v\n", err)
-			return
-		}
-		fmt.Printf("Roles for user %v:\n", username)
-		for _, role := range roles {
-			fmt.Printf("- %v\n", role)
-		}
+func (c *Cli) removeUserRole(ctx context.Context, args []string) (*Result, error) {
+	err := c.UserService.RemoveUserFromRole(ctx, args[0], args[1])
+	if err != nil {
+		return nil, fmt.Errorf("removing user from role: %w", err)
+	}
+	return &Result{Message: fmt.Sprintf("Removed user %v from role %v", args[0], args[1])}, nil
+}
+
+func (c *Cli) listUserRoles(ctx context.Context, args []string) (*Result, error) {
+	roles, err := c.UserService.GetUserRolesByUsername(ctx, args[0])
+	if err != nil {
+		return nil, fmt.Errorf("listing user roles: %w", err)
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	lines := []string{fmt.Sprintf("Roles for user %v:", args[0])}
+	for _, role := range roles {
+		lines = append(lines, "- "+role)
 	}
+	return &Result{Message: strings.Join(lines, "\n"), Data: roles}, nil
 }
@@ -0,0 +1,211 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var errMalformedExif = errors.New("malformed exif")
+
+const gpsInfoTag = 0x8825
+
+var (
+	jpegExifHeader = []byte("Exif\x00\x00")
+	jpegXmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	pngSignature   = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// StripGPS removes location data from a JPEG or PNG without re-encoding it. The GPS directory of the EXIF metadata is
+// emptied in place, leaving the rest of the EXIF (orientation, camera, ...) alone; metadata that can't be parsed, and
+// XMP that mentions GPS, is dropped entirely. Other types are returned as is. Reports whether anything changed.
+func StripGPS(data []byte, contentType string) ([]byte, bool) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJpegGPS(data)
+	case "image/png":
+		return stripPngGPS(data)
+	}
+	return data, false
+}
+
+func stripJpegGPS(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	changed := false
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			// Not a marker where one should be; leave the rest alone rather than guess
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// Fill byte
+			out = append(out, 0xFF)
+			pos++
+			continue
+		}
+		// Everything from the start of scan on is image data
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			break
+		}
+		segment := data[pos:end]
+		payload := segment[4:]
+
+		if marker == 0xE1 && bytes.HasPrefix(payload, jpegExifHeader) {
+			segment = bytes.Clone(segment)
+			stripped, err := zeroGPS(segment[4+len(jpegExifHeader):])
+			if err != nil {
+				changed = true
+				pos = end
+				continue
+			}
+			changed = changed || stripped
+		}
+		if marker == 0xE1 && bytes.HasPrefix(payload, jpegXmpHeader) && bytes.Contains(payload, []byte("GPS")) {
+			changed = true
+			pos = end
+			continue
+		}
+
+		out = append(out, segment...)
+		pos = end
+	}
+
+	if !changed {
+		return data, false
+	}
+	return append(out, data[pos:]...), true
+}
+
+func stripPngGPS(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return data, false
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	changed := false
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			break
+		}
+		chunk := data[pos:end]
+		chunkType := string(chunk[4:8])
+
+		if chunkType == "eXIf" {
+			chunk = bytes.Clone(chunk)
+			stripped, err := zeroGPS(chunk[8 : 8+length])
+			if err != nil {
+				changed = true
+				pos = end
+				continue
+			}
+			if stripped {
+				binary.BigEndian.PutUint32(chunk[8+length:], crc32.ChecksumIEEE(chunk[4:8+length]))
+				changed = true
+			}
+		}
+
+		out = append(out, chunk...)
+		pos = end
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	if !changed {
+		return data, false
+	}
+	return append(out, data[pos:]...), true
+}
+
+// exifTypeSizes is the size in bytes of each EXIF value type
+var exifTypeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// zeroGPS empties the GPS directory of a TIFF-structured EXIF block in place: every GPS value is zeroed and the
+// directory is left with no entries. Nothing moves, so every other offset in the block stays valid.
+// Reports whether there was a GPS directory.
+func zeroGPS(tiff []byte) (bool, error) {
+	if len(tiff) < 8 {
+		return false, errMalformedExif
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return false, errMalformedExif
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return false, errMalformedExif
+	}
+
+	entries, err := ifdEntries(tiff, order, int(order.Uint32(tiff[4:])))
+	if err != nil {
+		return false, err
+	}
+	gpsOffset := -1
+	for _, entry := range entries {
+		if order.Uint16(entry) == gpsInfoTag {
+			gpsOffset = int(order.Uint32(entry[8:]))
+		}
+	}
+	if gpsOffset < 0 {
+		return false, nil
+	}
+
+	gpsEntries, err := ifdEntries(tiff, order, gpsOffset)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range gpsEntries {
+		size := exifTypeSizes[order.Uint16(entry[2:])] * int(order.Uint32(entry[4:]))
+		if size > 4 {
+			valueOffset := int(order.Uint32(entry[8:]))
+			if valueOffset < 0 || size > len(tiff) || valueOffset > len(tiff)-size {
+				return false, errMalformedExif
+			}
+			clear(tiff[valueOffset : valueOffset+size])
+		}
+	}
+	// The entry count, the entries and the pointer to the next directory
+	clear(tiff[gpsOffset : gpsOffset+2+12*len(gpsEntries)+4])
+	return true, nil
+}
+
+// ifdEntries returns the 12-byte entries of the image file directory at offset
+func ifdEntries(tiff []byte, order binary.ByteOrder, offset int) ([][]byte, error) {
+	if offset < 8 || offset > len(tiff)-2 {
+		return nil, errMalformedExif
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	// Room for the entries plus the pointer to the next directory
+	if offset+2+12*count+4 > len(tiff) {
+		return nil, errMalformedExif
+	}
+	entries := make([][]byte, count)
+	for i := range count {
+		start := offset + 2 + 12*i
+		entries[i] = tiff[start : start+12]
+	}
+	return entries, nil
+}
@@ -0,0 +1,104 @@
+// Package imaging turns uploaded images into what clients need to show them cheaply: a thumbnail, the dimensions and a
+// blurhash placeholder. It only uses the standard library decoders, so PNG, JPEG and GIF are supported.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	// MaxPixels guards against decompression bombs: small files that decode into enormous images
+	MaxPixels = 50_000_000
+
+	ThumbnailSize = 400
+	// Blurhashes are computed from a tiny copy of the image, which looks the same once blurred
+	blurhashSourceSize = 64
+)
+
+var ErrUnsupportedImage = errors.New("unsupported image type")
+var ErrImageTooLarge = errors.New("image has too many pixels")
+
+// Supported reports whether Analyze can handle the MIME type
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Analysis describes an image. Thumbnail fits in ThumbnailSize by ThumbnailSize and is encoded as ThumbnailType: JPEG
+// for JPEG sources and PNG otherwise, so transparency survives.
+type Analysis struct {
+	Width         int
+	Height        int
+	Blurhash      string
+	Thumbnail     []byte
+	ThumbnailType string
+}
+
+// Analyze decodes the image and builds its thumbnail and blurhash. For animated GIFs only the first frame is used.
+func Analyze(data []byte, contentType string) (*Analysis, error) {
+	if !Supported(contentType) {
+		return nil, ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	var img image.Image
+	switch contentType {
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &Analysis{
+		Width:  config.Width,
+		Height: config.Height,
+	}
+
+	thumbnailWidth, thumbnailHeight := Fit(config.Width, config.Height, ThumbnailSize)
+	thumbnail := Resize(img, thumbnailWidth, thumbnailHeight)
+	var encoded bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&encoded, thumbnail, &jpeg.Options{Quality: 80})
+		analysis.ThumbnailType = "image/jpeg"
+	} else {
+		err = png.Encode(&encoded, thumbnail)
+		analysis.ThumbnailType = "image/png"
+	}
+	if err != nil {
+		return nil, err
+	}
+	analysis.Thumbnail = encoded.Bytes()
+
+	// Four components along the long side and three along the short one
+	xComponents, yComponents := 4, 3
+	if config.Height > config.Width {
+		xComponents, yComponents = 3, 4
+	}
+	smallWidth, smallHeight := Fit(thumbnailWidth, thumbnailHeight, blurhashSourceSize)
+	small := Resize(thumbnail, smallWidth, smallHeight)
+	analysis.Blurhash, err = Blurhash(small, xComponents, yComponents)
+	if err != nil {
+		return nil, err
+	}
+	return analysis, nil
+}
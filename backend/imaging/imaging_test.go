@@ -0,0 +1,204 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func solidImage(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, height, wantWidth, wantHeight int
+	}{
+		{4000, 3000, 400, 300},
+		{3000, 4000, 300, 400},
+		{200, 100, 200, 100},
+		{10000, 10, 400, 1},
+	}
+	for _, test := range tests {
+		width, height := Fit(test.width, test.height, 400)
+		if width != test.wantWidth || height != test.wantHeight {
+			t.Errorf("Fit(%v, %v, 400) = %v, %v, want %v, %v",
+				test.width, test.height, width, height, test.wantWidth, test.wantHeight)
+		}
+	}
+}
+
+func TestResizeAveragesPixels(t *testing.T) {
+	// Alternating black and white columns average out to grey
+	src := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := range 8 {
+		for x := range 8 {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	dst := Resize(src, 2, 2)
+	if got := dst.RGBAAt(1, 1); got.R != 128 || got.A != 255 {
+		t.Errorf("Resize() pixel = %v, want grey", got)
+	}
+}
+
+func TestBlurhashOfSolidBlack(t *testing.T) {
+	hash, err := Blurhash(solidImage(32, 32, color.Black), 4, 3)
+	if err != nil {
+		t.Fatalf("Blurhash() error = %v", err)
+	}
+	// Size flag L (4x3), no AC energy, a black DC and eleven zero AC components
+	if want := "L00000fQfQfQfQfQfQfQfQfQfQfQ"; hash != want {
+		t.Errorf("Blurhash() = %v, want %v", hash, want)
+	}
+}
+
+func TestBlurhashRejectsBadComponents(t *testing.T) {
+	if _, err := Blurhash(solidImage(4, 4, color.Black), 10, 3); err != ErrInvalidComponents {
+		t.Errorf("Blurhash() error = %v, want %v", err, ErrInvalidComponents)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	var encoded bytes.Buffer
+	png.Encode(&encoded, solidImage(1000, 500, color.RGBA{R: 200, G: 50, B: 50, A: 255}))
+
+	analysis, err := Analyze(encoded.Bytes(), "image/png")
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	if analysis.Width != 1000 || analysis.Height != 500 || analysis.ThumbnailType != "image/png" {
+		t.Errorf("Analyze() = %+v", analysis)
+	}
+	thumbnail, err := png.Decode(bytes.NewReader(analysis.Thumbnail))
+	if err != nil {
+		t.Fatalf("thumbnail doesn't decode: %v", err)
+	}
+	if bounds := thumbnail.Bounds(); bounds.Dx() != 400 || bounds.Dy() != 200 {
+		t.Errorf("thumbnail is %v, want 400x200", bounds)
+	}
+	if len(analysis.Blurhash) != 28 {
+		t.Errorf("Blurhash = %v, want 28 characters", analysis.Blurhash)
+	}
+}
+
+// gpsLatitude is the raw value of the GPSLatitude tag in testExif: 51° 30' 26"
+var gpsLatitude = []byte{51, 0, 0, 0, 1, 0, 0, 0, 30, 0, 0, 0, 1, 0, 0, 0, 26, 0, 0, 0, 1, 0, 0, 0}
+
+// testExif builds a little-endian EXIF block with an orientation tag and a GPS directory holding a latitude
+func testExif() []byte {
+	le := binary.LittleEndian
+	tiff := make([]byte, 56)
+	copy(tiff, "II")
+	le.PutUint16(tiff[2:], 42)
+	le.PutUint32(tiff[4:], 8)
+
+	// IFD0 at 8: Orientation and the GPS pointer
+	le.PutUint16(tiff[8:], 2)
+	le.PutUint16(tiff[10:], 0x0112)
+	le.PutUint16(tiff[12:], 3)
+	le.PutUint32(tiff[14:], 1)
+	le.PutUint16(tiff[18:], 6)
+	le.PutUint16(tiff[22:], gpsInfoTag)
+	le.PutUint16(tiff[24:], 4)
+	le.PutUint32(tiff[26:], 1)
+	le.PutUint32(tiff[30:], 38)
+
+	// GPS IFD at 38: GPSLatitude, three rationals stored at 56
+	le.PutUint16(tiff[38:], 1)
+	le.PutUint16(tiff[40:], 0x0002)
+	le.PutUint16(tiff[42:], 5)
+	le.PutUint32(tiff[44:], 3)
+	le.PutUint32(tiff[48:], 56)
+	return append(tiff, gpsLatitude...)
+}
+
+func TestStripGPSFromJpeg(t *testing.T) {
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, solidImage(16, 16, color.White), nil)
+	original := encoded.Bytes()
+
+	exif := append([]byte("Exif\x00\x00"), testExif()...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(2+len(exif)))
+	withExif := append(append(append([]byte{}, original[:2]...), append(app1, exif...)...), original[2:]...)
+
+	stripped, changed := StripGPS(withExif, "image/jpeg")
+	if !changed {
+		t.Fatal("StripGPS() reported no change")
+	}
+	if len(stripped) != len(withExif) {
+		t.Errorf("StripGPS() changed the length from %v to %v", len(withExif), len(stripped))
+	}
+	if bytes.Contains(stripped, gpsLatitude) {
+		t.Error("latitude is still in the image")
+	}
+	// Orientation survives
+	if !bytes.Contains(stripped, []byte{0x12, 0x01, 3, 0, 1, 0, 0, 0, 6, 0}) {
+		t.Error("orientation tag was removed")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped image doesn't decode: %v", err)
+	}
+
+	if _, changed := StripGPS(original, "image/jpeg"); changed {
+		t.Error("StripGPS() changed an image without EXIF")
+	}
+}
+
+func TestStripGPSFromPng(t *testing.T) {
+	var encoded bytes.Buffer
+	png.Encode(&encoded, solidImage(16, 16, color.White))
+	original := encoded.Bytes()
+
+	exif := testExif()
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(exif)))
+	chunk = append(chunk, "eXIf"...)
+	chunk = append(chunk, exif...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	// After the signature and the 25-byte IHDR chunk
+	withExif := append(append(append([]byte{}, original[:33]...), chunk...), original[33:]...)
+
+	stripped, changed := StripGPS(withExif, "image/png")
+	if !changed {
+		t.Fatal("StripGPS() reported no change")
+	}
+	if bytes.Contains(stripped, gpsLatitude) {
+		t.Error("latitude is still in the image")
+	}
+	// The decoder verifies every chunk's CRC
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped image doesn't decode: %v", err)
+	}
+}
+
+func TestStripGPSDropsMalformedExif(t *testing.T) {
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, solidImage(16, 16, color.White), nil)
+	original := encoded.Bytes()
+
+	exif := []byte("Exif\x00\x00garbage")
+	app1 := []byte{0xFF, 0xE1, 0, byte(2 + len(exif))}
+	withExif := append(append(append([]byte{}, original[:2]...), append(app1, exif...)...), original[2:]...)
+
+	stripped, changed := StripGPS(withExif, "image/jpeg")
+	if !changed || !bytes.Equal(stripped, original) {
+		t.Error("StripGPS() should drop EXIF it can't parse")
+	}
+}
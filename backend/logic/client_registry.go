@@ -2,29 +2,126 @@ package logic
 
 import (
 	"backend/model"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// ClientRegistry tracks the RoomClients connected to this instance. It is safe for concurrent use.
+// A user may have several RoomClients at once (one per browser tab), and presence is reference-counted: UserJoined is
+// only announced for their first session and UserLeft only for their last.
+// When Broker is set, events and presence are shared with every other instance through Redis; when it is nil the
+// registry behaves as a single-instance server.
+// Fan-out never blocks: a client whose SendChannel is full is evicted instead of holding up the publisher.
 type ClientRegistry struct {
-	Clients *map[uuid.UUID]*RoomClient
+	mu      sync.RWMutex
+	clients map[uuid.UUID]map[*RoomClient]struct{}
+	Broker  *RedisBroker
+	// presence is held by Connect and Disconnect until they have announced the user, so a quick connect and
+	// disconnect can't announce UserLeft before UserJoined. Users share them by presenceLock.
+	presence [presenceStripes]sync.Mutex
+
+	droppedEvents atomic.Int64
+	evictions     atomic.Int64
+}
+
+// ClientStats describes a single connection's queue
+type ClientStats struct {
+	UserID        uuid.UUID `json:"user_id"`
+	QueueDepth    int       `json:"queue_depth"`
+	QueueCapacity int       `json:"queue_capacity"`
+	Evicted       bool      `json:"evicted"`
+}
+
+// RegistryStats describes the fan-out on this instance. DroppedEvents counts every event that could not be queued for
+// a client, and Evictions counts the clients that were disconnected because of it.
+type RegistryStats struct {
+	Connections   int           `json:"connections"`
+	DroppedEvents int64         `json:"dropped_events"`
+	Evictions     int64         `json:"evictions"`
+	Clients       []ClientStats `json:"clients"`
+}
+
+// presenceStripes is how many presence locks users are spread over
+const presenceStripes = 64
+
+func (c *ClientRegistry) presenceLock(userID uuid.UUID) *sync.Mutex {
+	return &c.presence[int(userID[15])%presenceStripes]
+}
+
+func NewClientRegistry() *ClientRegistry {
+	return &ClientRegistry{
+		clients: make(map[uuid.UUID]map[*RoomClient]struct{}),
+	}
 }
 
 func (c *ClientRegistry) Connect(rc *RoomClient) {
-	(*c.Clients)[rc.UserID] = rc
+	presence := c.presenceLock(rc.UserID)
+	presence.Lock()
+	defer presence.Unlock()
+
+	c.mu.Lock()
+	sessions, exists := c.clients[rc.UserID]
+	if !exists {
+		sessions = make(map[*RoomClient]struct{})
+		c.clients[rc.UserID] = sessions
+	}
+	sessions[rc] = struct{}{}
+	firstSession := len(sessions) == 1
+	c.mu.Unlock()
+
+	if !firstSession {
+		return
+	}
+	// Only announce the user if this is their first connection anywhere in the cluster
+	if c.Broker != nil && !c.Broker.Join(rc.UserID) {
+		return
+	}
+
 	connectEvent := model.ServerEvent{
 		ServerEventType: model.UserJoined,
+		ServerEventTime: time.Now(),
 		Payload:         rc.UserID,
 	}
 
 	c.FanOutMessage(connectEvent, nil)
 }
 
-func (c *ClientRegistry) Disconnect(rc RoomClient) {
-	delete(*c.Clients, rc.UserID)
+func (c *ClientRegistry) Disconnect(rc *RoomClient) {
+	presence := c.presenceLock(rc.UserID)
+	presence.Lock()
+	defer presence.Unlock()
+
+	c.mu.Lock()
+	sessions, exists := c.clients[rc.UserID]
+	if !exists {
+		c.mu.Unlock()
+		return
+	}
+	if _, connected := sessions[rc]; !connected {
+		c.mu.Unlock()
+		return
+	}
+	delete(sessions, rc)
+	lastSession := len(sessions) == 0
+	if lastSession {
+		delete(c.clients, rc.UserID)
+	}
+	c.mu.Unlock()
+
+	if !lastSession {
+		return
+	}
+	if c.Broker != nil && !c.Broker.Leave(rc.UserID) {
+		return
+	}
 
 	disconnectEvent := model.ServerEvent{
 		ServerEventType: model.UserLeft,
+		ServerEventTime: time.Now(),
 		Payload:         rc.UserID,
 	}
 
@@ -32,12 +129,130 @@ func (c *ClientRegistry) Disconnect(rc RoomClient) {
 }
 
 func (c *ClientRegistry) IsOnline(userID uuid.UUID) bool {
-	return (*c.Clients)[userID] != nil
+	if c.Broker != nil {
+		return c.Broker.IsOnline(userID)
+	}
+	return c.SessionCount(userID) > 0
+}
+
+// SessionCount returns how many connections the user has to this instance
+func (c *ClientRegistry) SessionCount(userID uuid.UUID) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.clients[userID])
 }
 
+// FanOutMessage sends the event to every client in the cluster that may see it. Clients that can't see a persisted
+// event get it redacted, so their sequence stays gap-free; ephemeral events are only sent to those that can.
 func (c *ClientRegistry) FanOutMessage(message model.ServerEvent, roles *[]string) {
-	for _, rc := range *c.Clients {
-		message.Roles = roles
-		rc.SendChannel <- message
+	message.Roles = roles
+	c.deliver(message)
+	if c.Broker != nil {
+		c.Broker.Publish(message)
+	}
+}
+
+// UpdateRoles changes the roles that the user's connections throughout the cluster are filtered by
+func (c *ClientRegistry) UpdateRoles(userID uuid.UUID, roles []string) {
+	c.setRoles(userID, roles)
+	if c.Broker != nil {
+		c.Broker.PublishRoles(userID, roles)
+	}
+}
+
+// DisconnectUser closes every connection the user has throughout the cluster
+func (c *ClientRegistry) DisconnectUser(userID uuid.UUID) {
+	c.DisconnectSession(userID, uuid.Nil)
+}
+
+// DisconnectSession closes the connections opened with one of the user's sessions throughout the cluster, or all of
+// the user's connections for uuid.Nil
+func (c *ClientRegistry) DisconnectSession(userID, sessionID uuid.UUID) {
+	c.closeUser(userID, sessionID)
+	if c.Broker != nil {
+		c.Broker.PublishDisconnect(userID, sessionID)
+	}
+}
+
+// closeUser closes the user's connections to this instance only, just those of sessionID unless it is uuid.Nil
+func (c *ClientRegistry) closeUser(userID, sessionID uuid.UUID) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for rc := range c.clients[userID] {
+		if sessionID == uuid.Nil || rc.SessionID == sessionID {
+			rc.close()
+		}
+	}
+}
+
+// setRoles changes the roles of the user's connections to this instance only
+func (c *ClientRegistry) setRoles(userID uuid.UUID, roles []string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for rc := range c.clients[userID] {
+		rc.SetRoles(roles)
+	}
+}
+
+// deliver sends the event to the clients connected to this instance only
+func (c *ClientRegistry) deliver(message model.ServerEvent) {
+	recipients := newRecipients(message)
+	redacted := message.Redact()
+	for _, rc := range c.snapshot() {
+		event := message
+		if !recipients.includes(rc.UserID, rc.Roles()) {
+			if message.ServerEventOrder == 0 {
+				continue
+			}
+			event = redacted
+		}
+		if rc.isEvicted() {
+			c.droppedEvents.Add(1)
+			continue
+		}
+		if rc.trySend(event) {
+			continue
+		}
+		c.droppedEvents.Add(1)
+		if rc.evict() {
+			c.evictions.Add(1)
+			slog.Warn("Evicting slow SSE client",
+				slog.String("user_id", rc.UserID.String()),
+				slog.Int("queue_capacity", cap(rc.SendChannel)),
+			)
+		}
+	}
+}
+
+func (c *ClientRegistry) Stats() RegistryStats {
+	clients := c.snapshot()
+	stats := RegistryStats{
+		Connections:   len(clients),
+		DroppedEvents: c.droppedEvents.Load(),
+		Evictions:     c.evictions.Load(),
+		Clients:       make([]ClientStats, 0, len(clients)),
+	}
+	for _, rc := range clients {
+		stats.Clients = append(stats.Clients, ClientStats{
+			UserID:        rc.UserID,
+			QueueDepth:    len(rc.SendChannel),
+			QueueCapacity: cap(rc.SendChannel),
+			Evicted:       rc.isEvicted(),
+		})
+	}
+	return stats
+}
+
+// snapshot copies the connected clients so events can be sent without holding the lock
+func (c *ClientRegistry) snapshot() []*RoomClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	clients := make([]*RoomClient, 0, len(c.clients))
+	for _, sessions := range c.clients {
+		for rc := range sessions {
+			clients = append(clients, rc)
+		}
 	}
+	return clients
 }
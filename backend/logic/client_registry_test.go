@@ -0,0 +1,228 @@
+package logic
+
+import (
+	"backend/model"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestClientRegistryMultipleSessions(t *testing.T) {
+	registry := NewClientRegistry()
+
+	observer := newTestClient(uuid.New())
+	registry.Connect(observer)
+	expectEvent(t, observer, model.UserJoined)
+
+	userID := uuid.New()
+	firstTab := newTestClient(userID)
+	secondTab := newTestClient(userID)
+
+	registry.Connect(firstTab)
+	expectEvent(t, observer, model.UserJoined)
+	expectEvent(t, firstTab, model.UserJoined)
+
+	registry.Connect(secondTab)
+	expectNoEvent(t, observer)
+	if got := registry.SessionCount(userID); got != 2 {
+		t.Fatalf("SessionCount() = %v, want 2", got)
+	}
+
+	// Both tabs receive events
+	registry.FanOutMessage(model.ServerEvent{ServerEventType: model.NewMessage}, nil)
+	expectEvent(t, observer, model.NewMessage)
+	expectEvent(t, firstTab, model.NewMessage)
+	expectEvent(t, secondTab, model.NewMessage)
+
+	// Closing the first tab doesn't take the user offline
+	registry.Disconnect(firstTab)
+	expectNoEvent(t, observer)
+	if !registry.IsOnline(userID) {
+		t.Fatal("user should still be online through their second tab")
+	}
+
+	// Disconnecting the same session twice is a no-op
+	registry.Disconnect(firstTab)
+	expectNoEvent(t, observer)
+
+	registry.Disconnect(secondTab)
+	expectEvent(t, observer, model.UserLeft)
+	if registry.IsOnline(userID) {
+		t.Fatal("user should be offline after their last session closes")
+	}
+}
+
+func TestClientRegistryConcurrentSessions(t *testing.T) {
+	registry := NewClientRegistry()
+
+	// Big enough to hold every event of the test, so the observer is never evicted
+	observer := NewRoomClient(uuid.New(), []string{"default"}, 1000)
+	registry.Connect(observer)
+	expectEvent(t, observer, model.UserJoined)
+
+	const stopObserving model.ServerEventType = "stop_observing"
+	var observed sync.WaitGroup
+	observed.Add(1)
+	joined, left := 0, 0
+	go func() {
+		defer observed.Done()
+		for event := range observer.SendChannel {
+			switch event.ServerEventType {
+			case model.UserJoined:
+				joined++
+			case model.UserLeft:
+				left++
+			case stopObserving:
+				return
+			}
+		}
+	}()
+
+	const users = 20
+	const sessionsPerUser = 5
+
+	// Sessions keep draining until every goroutine is finished, since a fan-out may still be sending to a session that
+	// just disconnected
+	stopDraining := make(chan struct{})
+	var wg sync.WaitGroup
+	for range users {
+		userID := uuid.New()
+		// Every session of a user is connected before any of them disconnects, so each user joins exactly once
+		var connected sync.WaitGroup
+		connected.Add(sessionsPerUser)
+		for range sessionsPerUser {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rc := newTestClient(userID)
+				go func() {
+					for {
+						select {
+						case <-rc.SendChannel:
+						case <-stopDraining:
+							return
+						}
+					}
+				}()
+
+				registry.Connect(rc)
+				connected.Done()
+				registry.FanOutMessage(model.ServerEvent{ServerEventType: model.NewMessage}, nil)
+				registry.IsOnline(userID)
+				connected.Wait()
+				registry.Disconnect(rc)
+			}()
+		}
+	}
+	wg.Wait()
+	close(stopDraining)
+
+	// Queued behind every presence event, so the observer has counted them all once it stops
+	observer.SendChannel <- model.ServerEvent{ServerEventType: stopObserving}
+	observed.Wait()
+
+	if joined != users || left != users {
+		t.Errorf("observed %v joins and %v leaves, want %v of each", joined, left, users)
+	}
+	if got := len(registry.snapshot()); got != 1 {
+		t.Errorf("%v clients still connected, want only the observer", got)
+	}
+}
+
+func TestClientRegistryAnnouncesPresenceInOrder(t *testing.T) {
+	registry := NewClientRegistry()
+
+	observer := NewRoomClient(uuid.New(), []string{"default"}, 1000)
+	registry.Connect(observer)
+	expectEvent(t, observer, model.UserJoined)
+
+	// Each session connects and disconnects straight away, racing the others
+	userID := uuid.New()
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rc := NewRoomClient(userID, []string{"default"}, 1000)
+			registry.Connect(rc)
+			registry.Disconnect(rc)
+		}()
+	}
+	wg.Wait()
+
+	online := false
+	for len(observer.SendChannel) > 0 {
+		event := <-observer.SendChannel
+		switch event.ServerEventType {
+		case model.UserJoined:
+			if online {
+				t.Fatal("UserJoined announced for a user who is already online")
+			}
+			online = true
+		case model.UserLeft:
+			if !online {
+				t.Fatal("UserLeft announced for a user who is already offline")
+			}
+			online = false
+		}
+	}
+	if online {
+		t.Error("the user was left online after every session disconnected")
+	}
+}
+
+func TestClientRegistryEvictsSlowClients(t *testing.T) {
+	registry := NewClientRegistry()
+
+	stalled := newTestClient(uuid.New())
+	healthy := newTestClient(uuid.New())
+	registry.Connect(stalled)
+	registry.Connect(healthy)
+
+	// Nobody reads from the stalled client, so publishing past its buffer must not block
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for range DefaultSendBufferSize + 5 {
+			registry.FanOutMessage(model.ServerEvent{ServerEventType: model.NewMessage}, nil)
+			<-healthy.SendChannel
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(2 * time.Second):
+		t.Fatal("FanOutMessage blocked on a stalled client")
+	}
+
+	select {
+	case <-stalled.Evicted():
+	default:
+		t.Fatal("stalled client should have been evicted")
+	}
+	select {
+	case <-healthy.Evicted():
+		t.Fatal("healthy client should not have been evicted")
+	default:
+	}
+
+	stats := registry.Stats()
+	if stats.Evictions != 1 {
+		t.Errorf("Evictions = %v, want 1", stats.Evictions)
+	}
+	// Both UserJoined events were already queued for the stalled client, so only 48 of the 55 messages fit
+	if stats.DroppedEvents != 7 {
+		t.Errorf("DroppedEvents = %v, want 7", stats.DroppedEvents)
+	}
+	for _, client := range stats.Clients {
+		if client.UserID == stalled.UserID && (client.QueueDepth != DefaultSendBufferSize || !client.Evicted) {
+			t.Errorf("stalled client stats = %+v", client)
+		}
+	}
+
+	registry.Disconnect(stalled)
+	if got := registry.Stats().Connections; got != 1 {
+		t.Errorf("Connections = %v, want 1", got)
+	}
+}
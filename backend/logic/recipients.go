@@ -0,0 +1,69 @@
+package logic
+
+import (
+	"backend/model"
+
+	"github.com/google/uuid"
+)
+
+// recipients is who may see an event, worked out once when it is fanned out so each client is checked with a couple
+// of set lookups. It follows the same rule as role.CanSeeEvent: events addressed to users go to exactly those users,
+// and anything else goes to clients sharing one of its roles, or to any client with a role and none of its denied roles
+// if it has none.
+type recipients struct {
+	// users is nil unless the event is addressed to users
+	users map[uuid.UUID]struct{}
+	// roles is nil when the event isn't limited to any role
+	roles map[string]struct{}
+	// denied is only set when roles is nil
+	denied map[string]struct{}
+}
+
+func newRecipients(event model.ServerEvent) recipients {
+	var r recipients
+	if event.UserIDs != nil {
+		r.users = make(map[uuid.UUID]struct{}, len(*event.UserIDs))
+		for _, userID := range *event.UserIDs {
+			r.users[userID] = struct{}{}
+		}
+		return r
+	}
+	if event.Roles != nil && len(*event.Roles) > 0 {
+		r.roles = make(map[string]struct{}, len(*event.Roles))
+		for _, role := range *event.Roles {
+			r.roles[role] = struct{}{}
+		}
+		return r
+	}
+	if event.DeniedRoles != nil && len(*event.DeniedRoles) > 0 {
+		r.denied = make(map[string]struct{}, len(*event.DeniedRoles))
+		for _, role := range *event.DeniedRoles {
+			r.denied[role] = struct{}{}
+		}
+	}
+	return r
+}
+
+func (r recipients) includes(userID uuid.UUID, userRoles []string) bool {
+	if r.users != nil {
+		_, ok := r.users[userID]
+		return ok
+	}
+	if len(userRoles) == 0 {
+		return false
+	}
+	if r.roles == nil {
+		for _, role := range userRoles {
+			if _, ok := r.denied[role]; ok {
+				return false
+			}
+		}
+		return true
+	}
+	for _, role := range userRoles {
+		if _, ok := r.roles[role]; ok {
+			return true
+		}
+	}
+	return false
+}
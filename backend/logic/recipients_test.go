@@ -0,0 +1,129 @@
+package logic
+
+import (
+	"backend/model"
+	"backend/role"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRecipientsMatchCanSeeEvent(t *testing.T) {
+	member := uuid.New()
+	events := []model.ServerEvent{
+		{},
+		{Roles: &[]string{}},
+		{Roles: &[]string{"staff"}},
+		{Roles: &[]string{"default", "staff"}},
+		{UserIDs: &[]uuid.UUID{member}},
+		{UserIDs: &[]uuid.UUID{}, Roles: &[]string{"default"}},
+		{DeniedRoles: &[]string{"staff"}},
+		{Roles: &[]string{}, DeniedRoles: &[]string{"other"}},
+		{Roles: &[]string{"staff"}, DeniedRoles: &[]string{"staff"}},
+	}
+	users := []uuid.UUID{member, uuid.New()}
+	roleSets := [][]string{nil, {"default"}, {"staff"}, {"other", "staff"}}
+
+	for i, event := range events {
+		r := newRecipients(event)
+		for _, userID := range users {
+			for _, userRoles := range roleSets {
+				want := role.CanSeeEvent(event, userID, userRoles)
+				if got := r.includes(userID, userRoles); got != want {
+					t.Errorf("event %v, roles %v, member %v: includes() = %v, want %v",
+						i, userRoles, userID == member, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestClientRegistryFiltersByRole(t *testing.T) {
+	registry := NewClientRegistry()
+
+	staff := NewRoomClient(uuid.New(), []string{"default", "staff"}, DefaultSendBufferSize)
+	member := newTestClient(uuid.New())
+	registry.Connect(staff)
+	registry.Connect(member)
+	expectEvent(t, staff, model.UserJoined)
+	expectEvent(t, staff, model.UserJoined)
+	expectEvent(t, member, model.UserJoined)
+
+	// Persisted events are redacted for clients outside the audience so their sequence has no gaps
+	registry.FanOutMessage(model.ServerEvent{ServerEventType: model.NewMessage, ServerEventOrder: 1}, &[]string{"staff"})
+	expectEvent(t, staff, model.NewMessage)
+	if event := expectEvent(t, member, model.Redacted); event.ServerEventOrder != 1 || event.Payload != nil {
+		t.Errorf("redacted event = %+v", event)
+	}
+
+	// Ephemeral events are only sent to clients that can see them
+	registry.FanOutMessage(model.ServerEvent{ServerEventType: model.NewMessage}, &[]string{"staff"})
+	expectEvent(t, staff, model.NewMessage)
+	expectNoEvent(t, member)
+
+	// Events addressed to users skip everyone else, whatever their roles
+	registry.FanOutMessage(model.ServerEvent{
+		ServerEventType:  model.NewMessage,
+		ServerEventOrder: 2,
+		UserIDs:          &[]uuid.UUID{member.UserID},
+	}, nil)
+	expectEvent(t, staff, model.Redacted)
+	expectEvent(t, member, model.NewMessage)
+
+	registry.UpdateRoles(member.UserID, []string{"default", "staff"})
+	registry.FanOutMessage(model.ServerEvent{ServerEventType: model.NewMessage, ServerEventOrder: 3}, &[]string{"staff"})
+	expectEvent(t, member, model.NewMessage)
+}
+
+// BenchmarkFanOut measures delivering one event to 1,000 connected clients, a tenth of which hold the event's role.
+// The others get it redacted, as they would in production.
+func BenchmarkFanOut(b *testing.B) {
+	const clients = 1000
+	benchmarks := []struct {
+		name  string
+		event model.ServerEvent
+		roles *[]string
+	}{
+		{"public", model.ServerEvent{ServerEventType: model.NewMessage, ServerEventOrder: 1}, nil},
+		{"role", model.ServerEvent{ServerEventType: model.NewMessage, ServerEventOrder: 1}, &[]string{"role-0"}},
+		{"dm", model.ServerEvent{ServerEventType: model.NewMessage, ServerEventOrder: 1}, nil},
+	}
+
+	registry := NewClientRegistry()
+	var connected []*RoomClient
+	drain := func() {
+		for _, rc := range connected {
+			for len(rc.SendChannel) > 0 {
+				<-rc.SendChannel
+			}
+		}
+	}
+	for i := range clients {
+		rc := NewRoomClient(uuid.New(), []string{"default", "role-" + strconv.Itoa(i%10)}, DefaultSendBufferSize)
+		connected = append(connected, rc)
+		// Every connection announces itself to the others
+		registry.Connect(rc)
+		drain()
+	}
+	benchmarks[2].event.UserIDs = &[]uuid.UUID{connected[0].UserID, connected[1].UserID}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			drain()
+			b.ReportAllocs()
+			for i := 0; b.Loop(); i++ {
+				registry.FanOutMessage(bm.event, bm.roles)
+				// Keep every queue short of eviction without timing the reads
+				if i%(DefaultSendBufferSize/2) == 0 {
+					b.StopTimer()
+					drain()
+					b.StartTimer()
+				}
+			}
+			if stats := registry.Stats(); stats.Evictions != 0 {
+				b.Fatalf("%v clients were evicted", stats.Evictions)
+			}
+		})
+	}
+}
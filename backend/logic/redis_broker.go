@@ -0,0 +1,348 @@
+package logic
+
+import (
+	"backend/model"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultBrokerNamespace = "open_disc"
+	brokerTimeout          = 2 * time.Second
+	heartbeatInterval      = 10 * time.Second
+	// An instance that hasn't heartbeated in this long is considered dead and its connections are swept
+	instanceExpiry = 3 * heartbeatInterval
+)
+
+// adjustPresence atomically adds ARGV[2] to the user's cluster-wide connection count (KEYS[1]) and to this instance's
+// count (KEYS[2]), dropping fields that reach zero. Returns the new cluster-wide count.
+var adjustPresence = redis.NewScript(`
+local n = redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[2])
+if n <= 0 then redis.call('HDEL', KEYS[1], ARGV[1]) end
+local m = redis.call('HINCRBY', KEYS[2], ARGV[1], ARGV[2])
+if m <= 0 then redis.call('HDEL', KEYS[2], ARGV[1]) end
+return n
+`)
+
+// sweepInstance subtracts every count held by a dead instance (KEYS[2]) from the cluster-wide counts (KEYS[1]) and
+// returns the users that are no longer connected anywhere.
+var sweepInstance = redis.NewScript(`
+local entries = redis.call('HGETALL', KEYS[2])
+local left = {}
+for i = 1, #entries, 2 do
+  local n = redis.call('HINCRBY', KEYS[1], entries[i], -tonumber(entries[i + 1]))
+  if n <= 0 then
+    redis.call('HDEL', KEYS[1], entries[i])
+    table.insert(left, entries[i])
+  end
+end
+redis.call('DEL', KEYS[2])
+return left
+`)
+
+// RedisBroker relays ServerEvents between backend instances over Redis pub/sub and tracks which users are connected
+// anywhere in the cluster.
+// Every instance delivers its own events locally and publishes them; events received from other instances are
+// delivered to this instance's RoomClients only.
+type RedisBroker struct {
+	RedisClient    *redis.Client
+	ClientRegistry *ClientRegistry
+	InstanceID     uuid.UUID
+	// Namespace prefixes every channel and key, so several deployments (or tests) can share one Redis
+	Namespace string
+}
+
+// brokerMessage is what goes over the wire: an Event, a RoleUpdate or the user to Disconnect, limited to one Session
+// if it is set. Origin lets an instance ignore its own.
+type brokerMessage struct {
+	Origin     uuid.UUID       `json:"origin"`
+	Event      json.RawMessage `json:"event,omitempty"`
+	RoleUpdate *roleUpdate     `json:"role_update,omitempty"`
+	Disconnect *uuid.UUID      `json:"disconnect,omitempty"`
+	Session    *uuid.UUID      `json:"session,omitempty"`
+}
+
+// roleUpdate tells other instances that a user's roles have changed
+type roleUpdate struct {
+	UserID uuid.UUID `json:"user_id"`
+	Roles  []string  `json:"roles"`
+}
+
+func NewRedisBroker(redisClient *redis.Client, clientRegistry *ClientRegistry) *RedisBroker {
+	return &RedisBroker{
+		RedisClient:    redisClient,
+		ClientRegistry: clientRegistry,
+		InstanceID:     uuid.New(),
+		Namespace:      defaultBrokerNamespace,
+	}
+}
+
+func (b *RedisBroker) eventChannel() string {
+	return b.Namespace + ":server_events"
+}
+
+func (b *RedisBroker) presenceKey() string {
+	return b.Namespace + ":presence:users"
+}
+
+func (b *RedisBroker) instancesKey() string {
+	return b.Namespace + ":presence:instances"
+}
+
+func (b *RedisBroker) instancePresenceKey(instanceID string) string {
+	return b.Namespace + ":presence:instance:" + instanceID
+}
+
+// Start subscribes to the event channel and begins heartbeating. It returns once the subscription is confirmed, so
+// events published by other instances after Start returns are guaranteed to be received.
+func (b *RedisBroker) Start(ctx context.Context) error {
+	pubsub := b.RedisClient.Subscribe(ctx, b.eventChannel())
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	if err := b.heartbeat(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+
+	go b.receive(ctx, pubsub)
+	go b.maintainPresence(ctx)
+	return nil
+}
+
+func (b *RedisBroker) receive(ctx context.Context, pubsub *redis.PubSub) {
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var message brokerMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+				slog.Error("Failed to decode broker message", slog.String("error", err.Error()))
+				continue
+			}
+			if message.Origin == b.InstanceID {
+				continue
+			}
+			if message.RoleUpdate != nil {
+				b.ClientRegistry.setRoles(message.RoleUpdate.UserID, message.RoleUpdate.Roles)
+				continue
+			}
+			if message.Disconnect != nil {
+				sessionID := uuid.Nil
+				if message.Session != nil {
+					sessionID = *message.Session
+				}
+				b.ClientRegistry.closeUser(*message.Disconnect, sessionID)
+				continue
+			}
+			event, err := decodeEvent(message.Event)
+			if err != nil {
+				slog.Error("Failed to decode broker message", slog.String("error", err.Error()))
+				continue
+			}
+			b.ClientRegistry.deliver(event)
+		}
+	}
+}
+
+// Publish sends the event to every other instance
+func (b *RedisBroker) Publish(event model.ServerEvent) {
+	asJson, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode server event for broker", slog.String("error", err.Error()))
+		return
+	}
+	err = b.publish(brokerMessage{Origin: b.InstanceID, Event: asJson})
+	if err != nil {
+		slog.Error("Failed to publish server event",
+			slog.String("eventType", string(event.ServerEventType)),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// PublishRoles sends a user's new roles to every other instance
+func (b *RedisBroker) PublishRoles(userID uuid.UUID, roles []string) {
+	err := b.publish(brokerMessage{Origin: b.InstanceID, RoleUpdate: &roleUpdate{UserID: userID, Roles: roles}})
+	if err != nil {
+		slog.Error("Failed to publish role update",
+			slog.String("user_id", userID.String()),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// PublishDisconnect has every other instance close the user's connections, or only those of sessionID unless it is
+// uuid.Nil
+func (b *RedisBroker) PublishDisconnect(userID, sessionID uuid.UUID) {
+	message := brokerMessage{Origin: b.InstanceID, Disconnect: &userID}
+	if sessionID != uuid.Nil {
+		message.Session = &sessionID
+	}
+	err := b.publish(message)
+	if err != nil {
+		slog.Error("Failed to publish disconnect",
+			slog.String("user_id", userID.String()),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+func (b *RedisBroker) publish(message brokerMessage) error {
+	asJson, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	return b.RedisClient.Publish(ctx, b.eventChannel(), asJson).Err()
+}
+
+func decodeEvent(data json.RawMessage) (model.ServerEvent, error) {
+	// Keep the payload as raw JSON so it is re-sent to clients exactly as the origin encoded it
+	var payload json.RawMessage
+	event := model.ServerEvent{Payload: &payload}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return model.ServerEvent{}, err
+	}
+	event.Payload = payload
+	return event, nil
+}
+
+// Join records a new connection for the user and reports whether it is their first one anywhere in the cluster
+func (b *RedisBroker) Join(userID uuid.UUID) bool {
+	count, err := b.adjust(userID, 1)
+	if err != nil {
+		slog.Error("Failed to record user presence",
+			slog.String("user_id", userID.String()),
+			slog.String("error", err.Error()),
+		)
+		return true
+	}
+	return count == 1
+}
+
+// Leave removes a connection for the user and reports whether it was their last one anywhere in the cluster
+func (b *RedisBroker) Leave(userID uuid.UUID) bool {
+	count, err := b.adjust(userID, -1)
+	if err != nil {
+		slog.Error("Failed to remove user presence",
+			slog.String("user_id", userID.String()),
+			slog.String("error", err.Error()),
+		)
+		return true
+	}
+	return count <= 0
+}
+
+func (b *RedisBroker) adjust(userID uuid.UUID, delta int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	return adjustPresence.Run(ctx, b.RedisClient,
+		[]string{b.presenceKey(), b.instancePresenceKey(b.InstanceID.String())},
+		userID.String(), delta,
+	).Int64()
+}
+
+// IsOnline reports whether the user is connected to any instance
+func (b *RedisBroker) IsOnline(userID uuid.UUID) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	count, err := b.RedisClient.HGet(ctx, b.presenceKey(), userID.String()).Int64()
+	if err != nil {
+		if err != redis.Nil {
+			slog.Error("Failed to read user presence",
+				slog.String("user_id", userID.String()),
+				slog.String("error", err.Error()),
+			)
+		}
+		return false
+	}
+	return count > 0
+}
+
+func (b *RedisBroker) maintainPresence(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.heartbeat(ctx); err != nil {
+				slog.Error("Failed to heartbeat broker instance", slog.String("error", err.Error()))
+			}
+			if err := b.sweepDeadInstances(ctx); err != nil {
+				slog.Error("Failed to sweep dead broker instances", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+func (b *RedisBroker) heartbeat(ctx context.Context) error {
+	return b.RedisClient.ZAdd(ctx, b.instancesKey(), redis.Z{
+		Score:  float64(time.Now().Unix()),
+		Member: b.InstanceID.String(),
+	}).Err()
+}
+
+// sweepDeadInstances releases the connections held by instances that stopped heartbeating, e.g. because they crashed,
+// and announces UserLeft for anyone who is no longer connected anywhere.
+func (b *RedisBroker) sweepDeadInstances(ctx context.Context) error {
+	cutoff := time.Now().Add(-instanceExpiry).Unix()
+	dead, err := b.RedisClient.ZRangeByScore(ctx, b.instancesKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(cutoff, 10),
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, instanceID := range dead {
+		// Only the instance that manages to remove the entry sweeps it
+		removed, err := b.RedisClient.ZRem(ctx, b.instancesKey(), instanceID).Result()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			continue
+		}
+
+		left, err := sweepInstance.Run(ctx, b.RedisClient,
+			[]string{b.presenceKey(), b.instancePresenceKey(instanceID)},
+		).StringSlice()
+		if err != nil {
+			return err
+		}
+		slog.Info("Swept dead broker instance",
+			slog.String("instance_id", instanceID),
+			slog.Int("users_left", len(left)),
+		)
+
+		for _, userID := range left {
+			asUuid, err := uuid.Parse(userID)
+			if err != nil {
+				continue
+			}
+			b.ClientRegistry.FanOutMessage(model.ServerEvent{
+				ServerEventType: model.UserLeft,
+				Payload:         asUuid,
+			}, nil)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package logic
+
+import (
+	"backend/model"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// These tests run several brokers against one Redis. By default that is an in-process miniredis; set REDIS_TEST_ADDR
+// to run them against a real server instead, e.g.
+// REDIS_TEST_ADDR=localhost:6379 go test ./logic/
+// Each test uses its own namespace so it never touches a deployment's keys.
+
+func redisTestAddr(t *testing.T) string {
+	if addr := os.Getenv("REDIS_TEST_ADDR"); addr != "" {
+		return addr
+	}
+	return miniredis.RunT(t).Addr()
+}
+
+func newTestInstance(t *testing.T, addr, namespace string) (*ClientRegistry, *RedisBroker) {
+	registry := NewClientRegistry()
+
+	redisClient := redis.NewClient(&redis.Options{Addr: addr})
+	broker := NewRedisBroker(redisClient, registry)
+	broker.Namespace = namespace
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		redisClient.Del(context.Background(),
+			broker.presenceKey(),
+			broker.instancesKey(),
+			broker.instancePresenceKey(broker.InstanceID.String()),
+		)
+		redisClient.Close()
+	})
+
+	if err := broker.Start(ctx); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	registry.Broker = broker
+	return registry, broker
+}
+
+// newTestClient connects with the default role, so it receives events that aren't limited to other roles
+func newTestClient(userID uuid.UUID) *RoomClient {
+	return NewRoomClient(userID, []string{"default"}, DefaultSendBufferSize)
+}
+
+func expectEvent(t *testing.T, rc *RoomClient, eventType model.ServerEventType) model.ServerEvent {
+	t.Helper()
+	select {
+	case event := <-rc.SendChannel:
+		if event.ServerEventType != eventType {
+			t.Fatalf("got %v event, want %v", event.ServerEventType, eventType)
+		}
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %v event", eventType)
+	}
+	return model.ServerEvent{}
+}
+
+func expectNoEvent(t *testing.T, rc *RoomClient) {
+	t.Helper()
+	select {
+	case event := <-rc.SendChannel:
+		t.Fatalf("got unexpected %v event", event.ServerEventType)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// decodeBrokerMessage decodes an event message the same way RedisBroker.receive does
+func decodeBrokerMessage(data []byte) (model.ServerEvent, uuid.UUID, error) {
+	var message brokerMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return model.ServerEvent{}, uuid.Nil, err
+	}
+	event, err := decodeEvent(message.Event)
+	return event, message.Origin, err
+}
+
+func TestDecodeBrokerMessageKeepsPayload(t *testing.T) {
+	origin := uuid.New()
+	event := model.ServerEvent{
+		ServerEventType:  model.NewMessage,
+		ServerEventOrder: 7,
+		Payload:          map[string]string{"message": "hello"},
+		Roles:            &[]string{"default"},
+	}
+	asJson, _ := json.Marshal(event)
+	data, _ := json.Marshal(brokerMessage{Origin: origin, Event: asJson})
+
+	got, gotOrigin, err := decodeBrokerMessage(data)
+	if err != nil {
+		t.Fatalf("decodeBrokerMessage() error = %v", err)
+	}
+	if gotOrigin != origin {
+		t.Errorf("origin = %v, want %v", gotOrigin, origin)
+	}
+	if got.ServerEventOrder != 7 || got.Roles == nil || (*got.Roles)[0] != "default" {
+		t.Errorf("decodeBrokerMessage() = %+v", got)
+	}
+	if payload, _ := json.Marshal(got.Payload); string(payload) != `{"message":"hello"}` {
+		t.Errorf("payload = %s", payload)
+	}
+}
+
+func TestRedisBrokerDeliversAcrossInstances(t *testing.T) {
+	addr := redisTestAddr(t)
+	namespace := "test:" + uuid.NewString()
+	registryA, _ := newTestInstance(t, addr, namespace)
+	registryB, _ := newTestInstance(t, addr, namespace)
+
+	rc := newTestClient(uuid.New())
+	registryB.Connect(rc)
+	expectEvent(t, rc, model.UserJoined)
+
+	registryA.FanOutMessage(model.ServerEvent{
+		ServerEventType:  model.NewMessage,
+		ServerEventOrder: 1,
+		Payload:          map[string]string{"message": "hello"},
+	}, &[]string{"default"})
+
+	event := expectEvent(t, rc, model.NewMessage)
+	if event.Roles == nil || len(*event.Roles) != 1 {
+		t.Errorf("roles = %v, want [default]", event.Roles)
+	}
+	expectNoEvent(t, rc)
+}
+
+func TestRedisBrokerSharesRoleUpdates(t *testing.T) {
+	addr := redisTestAddr(t)
+	namespace := "test:" + uuid.NewString()
+	registryA, _ := newTestInstance(t, addr, namespace)
+	registryB, _ := newTestInstance(t, addr, namespace)
+
+	rc := newTestClient(uuid.New())
+	registryB.Connect(rc)
+	expectEvent(t, rc, model.UserJoined)
+
+	registryA.UpdateRoles(rc.UserID, []string{"default", "staff"})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(rc.Roles()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("roles = %v, want [default staff]", rc.Roles())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	registryA.FanOutMessage(model.ServerEvent{ServerEventType: model.NewMessage, ServerEventOrder: 1}, &[]string{"staff"})
+	expectEvent(t, rc, model.NewMessage)
+}
+
+func TestRedisBrokerSharesDisconnects(t *testing.T) {
+	addr := redisTestAddr(t)
+	namespace := "test:" + uuid.NewString()
+	registryA, _ := newTestInstance(t, addr, namespace)
+	registryB, _ := newTestInstance(t, addr, namespace)
+
+	rc := newTestClient(uuid.New())
+	registryB.Connect(rc)
+	expectEvent(t, rc, model.UserJoined)
+
+	registryA.DisconnectUser(rc.UserID)
+	select {
+	case <-rc.Closed():
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection on the other instance was not closed")
+	}
+}
+
+func TestRedisBrokerSharesSessionDisconnects(t *testing.T) {
+	addr := redisTestAddr(t)
+	namespace := "test:" + uuid.NewString()
+	registryA, _ := newTestInstance(t, addr, namespace)
+	registryB, _ := newTestInstance(t, addr, namespace)
+
+	userID := uuid.New()
+	revoked := newTestClient(userID)
+	revoked.SessionID = uuid.New()
+	other := newTestClient(userID)
+	other.SessionID = uuid.New()
+	registryB.Connect(revoked)
+	expectEvent(t, revoked, model.UserJoined)
+	registryB.Connect(other)
+
+	registryA.DisconnectSession(userID, revoked.SessionID)
+	select {
+	case <-revoked.Closed():
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection of the revoked session was not closed")
+	}
+	select {
+	case <-other.Closed():
+		t.Fatal("connection of another session was closed")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRedisBrokerPresenceAcrossInstances(t *testing.T) {
+	addr := redisTestAddr(t)
+	namespace := "test:" + uuid.NewString()
+	registryA, _ := newTestInstance(t, addr, namespace)
+	registryB, _ := newTestInstance(t, addr, namespace)
+
+	observer := newTestClient(uuid.New())
+	registryA.Connect(observer)
+	expectEvent(t, observer, model.UserJoined)
+
+	userID := uuid.New()
+	onA := newTestClient(userID)
+	onB := newTestClient(userID)
+
+	registryA.Connect(onA)
+	expectEvent(t, observer, model.UserJoined)
+
+	// A second connection on another instance is not announced
+	registryB.Connect(onB)
+	expectNoEvent(t, observer)
+	if !registryA.IsOnline(userID) || !registryB.IsOnline(userID) {
+		t.Fatal("user should be online on both instances")
+	}
+
+	registryA.Disconnect(onA)
+	expectNoEvent(t, observer)
+	if !registryA.IsOnline(userID) {
+		t.Fatal("user should still be online through instance B")
+	}
+
+	registryB.Disconnect(onB)
+	event := expectEvent(t, observer, model.UserLeft)
+	if payload, _ := json.Marshal(event.Payload); string(payload) != `"`+userID.String()+`"` {
+		t.Errorf("payload = %s, want %v", payload, userID)
+	}
+	if registryA.IsOnline(userID) {
+		t.Error("user should be offline")
+	}
+}
+
+func TestRedisBrokerSweepsDeadInstances(t *testing.T) {
+	addr := redisTestAddr(t)
+	namespace := "test:" + uuid.NewString()
+	registryA, brokerA := newTestInstance(t, addr, namespace)
+	registryB, brokerB := newTestInstance(t, addr, namespace)
+
+	observer := newTestClient(uuid.New())
+	registryA.Connect(observer)
+	expectEvent(t, observer, model.UserJoined)
+
+	userID := uuid.New()
+	registryB.Connect(newTestClient(userID))
+	expectEvent(t, observer, model.UserJoined)
+
+	// Pretend instance B stopped heartbeating a long time ago
+	ctx := context.Background()
+	brokerA.RedisClient.ZAdd(ctx, brokerA.instancesKey(), redis.Z{
+		Score:  float64(time.Now().Add(-time.Hour).Unix()),
+		Member: brokerB.InstanceID.String(),
+	})
+
+	if err := brokerA.sweepDeadInstances(ctx); err != nil {
+		t.Fatalf("sweepDeadInstances() = %v", err)
+	}
+	expectEvent(t, observer, model.UserLeft)
+	if registryA.IsOnline(userID) {
+		t.Error("user on a dead instance should be offline")
+	}
+}
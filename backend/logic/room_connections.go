@@ -3,17 +3,97 @@ package logic
 import (
 	"backend/model"
 	"encoding/json"
+	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 )
 
+// DefaultSendBufferSize is how many events an SSE connection may fall behind by before it is evicted
+const DefaultSendBufferSize = 50
+
 // RoomClient represents a user that is actively connected to open_disc
 // UserID is their unique user identifier
 // SendChannel is the channel that their SSE connection will receive messages from
+// The user's roles are kept on the client so the registry can decide what to send it without looking them up
 type RoomClient struct {
-	UserID      uuid.UUID
+	UserID uuid.UUID
+	// SessionID is the session the connection was opened with, so revoking the session closes it
+	SessionID   uuid.UUID
 	Nickname    string
 	SendChannel chan model.ServerEvent
+
+	roles     atomic.Pointer[[]string]
+	evicted   chan struct{}
+	evictOnce sync.Once
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewRoomClient(userID uuid.UUID, roles []string, bufferSize int) *RoomClient {
+	rc := &RoomClient{
+		UserID:      userID,
+		SendChannel: make(chan model.ServerEvent, bufferSize),
+		evicted:     make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	rc.SetRoles(roles)
+	return rc
+}
+
+// Roles returns the roles events are currently filtered by
+func (rc *RoomClient) Roles() []string {
+	return *rc.roles.Load()
+}
+
+// SetRoles replaces the client's roles. Events fanned out afterwards are filtered by the new ones.
+func (rc *RoomClient) SetRoles(roles []string) {
+	rc.roles.Store(&roles)
+}
+
+// Evicted is closed once the client's SendChannel has overflowed. The connection should tell the client to resync and
+// then close, since it has missed events.
+func (rc *RoomClient) Evicted() <-chan struct{} {
+	return rc.evicted
+}
+
+// Closed is closed once the user may no longer be connected, such as when their account is disabled. The connection
+// should end without sending anything else.
+func (rc *RoomClient) Closed() <-chan struct{} {
+	return rc.closed
+}
+
+func (rc *RoomClient) close() {
+	rc.closeOnce.Do(func() { close(rc.closed) })
+}
+
+func (rc *RoomClient) isEvicted() bool {
+	select {
+	case <-rc.evicted:
+		return true
+	default:
+		return false
+	}
+}
+
+// evict reports whether this call was the one that evicted the client
+func (rc *RoomClient) evict() bool {
+	evicted := false
+	rc.evictOnce.Do(func() {
+		close(rc.evicted)
+		evicted = true
+	})
+	return evicted
+}
+
+// trySend queues the event without blocking and reports whether there was room for it
+func (rc *RoomClient) trySend(message model.ServerEvent) bool {
+	select {
+	case rc.SendChannel <- message:
+		return true
+	default:
+		return false
+	}
 }
 
 // Room represents a single room active on the server
@@ -26,6 +106,38 @@ type Room struct {
 	Name           string
 }
 
+// Rooms is the rooms active on the server, by ID. It is safe for concurrent use, and rooms' names are only changed
+// through it.
+type Rooms struct {
+	mu    sync.RWMutex
+	rooms map[uuid.UUID]*Room
+}
+
+func NewRooms() *Rooms {
+	return &Rooms{rooms: make(map[uuid.UUID]*Room)}
+}
+
+func (r *Rooms) Add(room *Room) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rooms[room.RoomID] = room
+}
+
+func (r *Rooms) Remove(roomID uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.rooms, roomID)
+}
+
+// Rename changes the name of the room, if it is active
+func (r *Rooms) Rename(roomID uuid.UUID, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if room, exists := r.rooms[roomID]; exists {
+		room.Name = name
+	}
+}
+
 func (r *Room) Send(message model.Message) error {
 	asJson, err := json.Marshal(message)
 	if err != nil {
@@ -37,9 +149,7 @@ func (r *Room) Send(message model.Message) error {
 		Payload:         asJson,
 	}
 
-	for _, client := range *r.ClientRegistry.Clients {
-		client.SendChannel <- roomEvent
-	}
+	r.ClientRegistry.FanOutMessage(roomEvent, nil)
 
 	return nil
 }
@@ -0,0 +1,32 @@
+package logic
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Rooms and DMs are created, renamed and deleted by any number of requests at once. Run with -race.
+func TestRoomsConcurrentWriters(t *testing.T) {
+	rooms := NewRooms()
+	registry := NewClientRegistry()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			roomID := uuid.New()
+			rooms.Add(&Room{ClientRegistry: registry, RoomID: roomID, Name: "dm"})
+			rooms.Rename(roomID, fmt.Sprint("room ", i))
+			rooms.Remove(roomID)
+		}()
+	}
+	wg.Wait()
+
+	if len(rooms.rooms) != 0 {
+		t.Errorf("%v rooms left, want none", len(rooms.rooms))
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"backend/admin"
+	"backend/attachment"
 	"backend/auth"
 	"backend/cli"
 	"backend/logic"
 	"backend/message"
 	"backend/role"
 	"backend/room"
+	"backend/serverevent"
 	"backend/util"
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 
@@ -18,7 +22,6 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
@@ -32,16 +35,27 @@ func setupRouter() *gin.Engine {
 	return router
 }
 
-var rooms map[uuid.UUID]*logic.Room
-var clients map[uuid.UUID]*logic.RoomClient
+var rooms *logic.Rooms
+
+func adminSocketPath() string {
+	if path := os.Getenv("ADMIN_SOCKET"); path != "" {
+		return path
+	}
+	return cli.DefaultSocketPath
+}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		// Only needed for ADMIN_SOCKET, which may also be set in the environment
+		godotenv.Load("local.env")
+		os.Exit(cli.RunAdmin(os.Args[2:], adminSocketPath(), os.Stdout, os.Stderr))
+	}
+
 	fmt.Println("Starting application")
 
-	rooms = make(map[uuid.UUID]*logic.Room)
-	clients = make(map[uuid.UUID]*logic.RoomClient)
+	rooms = logic.NewRooms()
 
-	clientRegistry := logic.ClientRegistry{Clients: &clients}
+	clientRegistry := logic.NewClientRegistry()
 
 	ctx := context.Background()
 
@@ -65,6 +79,14 @@ func main() {
 	})
 	defer redisClient.Close()
 
+	// Share server events and presence with any other backend instances through Redis
+	broker := logic.NewRedisBroker(redisClient, clientRegistry)
+	err = broker.Start(ctx)
+	if err != nil {
+		log.Fatalf("Unable to start Redis broker: %v\n", err)
+	}
+	clientRegistry.Broker = broker
+
 	// Create DB Pool
 	pool, err := pgxpool.New(ctx, dbURL)
 	if err != nil {
@@ -72,8 +94,16 @@ func main() {
 	}
 	defer pool.Close()
 
-	services := util.CreateServices(pool, jwtSecret, &rooms, &clientRegistry, redisClient)
-	handlers := util.CreateHandlers(services, &rooms, &clientRegistry)
+	attachmentConfig, blobStore, err := attachment.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure attachment storage: %v\n", err)
+	}
+
+	services := util.CreateServices(pool, jwtSecret, rooms, clientRegistry, redisClient, attachmentConfig, blobStore)
+	handlers := util.CreateHandlers(services, rooms, clientRegistry)
+
+	// Make thumbnails of uploaded images in the background
+	go attachment.NewImageWorker(&services.AttachmentService).Run(ctx)
 
 	// Add all existing rooms to memory
 	allRooms, err := services.RoomsService.GetAll(context.Background(), nil)
@@ -82,38 +112,61 @@ func main() {
 	}
 
 	for _, room := range allRooms {
-		connectionRoom := logic.Room{
-			ClientRegistry: &clientRegistry,
+		rooms.Add(&logic.Room{
+			ClientRegistry: clientRegistry,
 			RoomID:         room.ID,
 			Name:           room.Name,
-		}
-		rooms[room.ID] = &connectionRoom
+		})
 	}
 
+	adminCli := cli.NewCli(
+		&services.Otc,
+		&services.RoleService,
+		&services.UsersService,
+		&services.RoomsService,
+		&handlers.RoomHandler,
+		&services.TwoFactorService,
+	)
+	// Logs go through the CLI so they don't garble its prompt. Gin picks its writers up when the router is made.
+	gin.DefaultWriter = adminCli.Output()
+	gin.DefaultErrorWriter = adminCli.Output()
+	log.SetOutput(adminCli.Output())
+
 	// Router setup
 	router := setupRouter()
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"https://chat.lee.fail"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Authorization", "Content-Type"},
 		AllowCredentials: true,
 	}))
 	router.Use(auth.AuthMiddleware(&services.TokenService))
 
 	user.BindUserRoutes(router, &handlers.UserHandler)
+	role.BindRoleRoutes(router, &handlers.RoleHandler)
+	admin.BindAdminRoutes(router, &handlers.AdminHandler)
 	room.BindRoomRoutes(router, &handlers.RoomHandler)
 	auth.BindAuthRoutes(router, &handlers.AuthHandler)
 	message.BindMessageRoutes(router, &handlers.MessagesHandler)
+	serverevent.BindServerEventRoutes(router, &handlers.EventsHandler)
+	attachment.BindAttachmentRoutes(router, &handlers.AttachmentsHandler)
 
 	router.GET(
 		"/connect",
 		handlers.SseHandler.EstablishSSEConnection,
 	)
+	router.GET("/connect/stats",
+		role.RequirePermission(&services.RoleService, role.ViewStats),
+		handlers.SseHandler.HandleGetConnectionStats,
+	)
 
 	fmt.Println("Starting CLI")
-	otc := auth.Otc{DB: pool}
-	roleService := role.Service{DB: pool}
-	cli := cli.NewCli(&otc, &roleService, &services.UsersService, &services.RoomsService)
-	go cli.Run()
+	go adminCli.Run()
+	go func() {
+		err := adminCli.Serve(ctx, adminSocketPath())
+		if err != nil {
+			slog.Error("Admin socket stopped", slog.String("error", err.Error()))
+		}
+	}()
 	router.Run(":8080")
 }
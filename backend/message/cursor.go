@@ -0,0 +1,52 @@
+package message
+
+import (
+	"backend/model"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a position in a list of messages ordered by (timestamp, id). Clients only ever see it encoded, so its
+// contents can change without breaking them.
+type Cursor struct {
+	Timestamp time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+func cursorFor(message model.Message) Cursor {
+	return Cursor{Timestamp: message.TimeStamp, ID: message.ID}
+}
+
+// firstCursor and lastCursor point at the ends of a page of messages
+func firstCursor(messages []model.Message) *Cursor {
+	cursor := cursorFor(messages[0])
+	return &cursor
+}
+
+func lastCursor(messages []model.Message) *Cursor {
+	cursor := cursorFor(messages[len(messages)-1])
+	return &cursor
+}
+
+func (c Cursor) Encode() string {
+	asJson, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(asJson)
+}
+
+func DecodeCursor(encoded string) (*Cursor, error) {
+	asJson, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(asJson, &cursor); err != nil || cursor.Timestamp.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,31 @@
+package message
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	want := Cursor{
+		Timestamp: time.Date(2024, 1, 15, 9, 30, 0, 123456000, time.UTC),
+		ID:        uuid.New(),
+	}
+
+	got, err := DecodeCursor(want.Encode())
+	if err != nil {
+		t.Fatalf("DecodeCursor() error = %v", err)
+	}
+	if !got.Timestamp.Equal(want.Timestamp) || got.ID != want.ID {
+		t.Errorf("DecodeCursor() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, encoded := range []string{"", "not base64!", "bm90IGpzb24", "e30"} {
+		if _, err := DecodeCursor(encoded); err != ErrInvalidCursor {
+			t.Errorf("DecodeCursor(%q) error = %v, want %v", encoded, err, ErrInvalidCursor)
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package message
 
 import (
+	"backend/attachment"
 	"backend/model"
 	"backend/role"
 	"backend/room"
 	"backend/serverevent"
 	"backend/user"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,44 +19,194 @@ import (
 )
 
 type MessageHandler struct {
-	ServerEventStore *serverevent.ServerEventStore
-	UserService      *user.UserService
-	RoomService      *room.RoomService
-	MessageService   *Service
+	ServerEventStore  *serverevent.ServerEventStore
+	UserService       *user.UserService
+	RoomService       *room.RoomService
+	RoleService       *role.Service
+	MessageService    *Service
+	AttachmentService *attachment.Service
 }
 
 func NewMessageHandler(
 	serverEventStore *serverevent.ServerEventStore,
 	userService *user.UserService,
 	roomService *room.RoomService,
+	roleService *role.Service,
 	messageService *Service,
+	attachmentService *attachment.Service,
 ) *MessageHandler {
 	return &MessageHandler{
-		ServerEventStore: serverEventStore,
-		UserService:      userService,
-		RoomService:      roomService,
-		MessageService:   messageService,
+		ServerEventStore:  serverEventStore,
+		UserService:       userService,
+		RoomService:       roomService,
+		RoleService:       roleService,
+		MessageService:    messageService,
+		AttachmentService: attachmentService,
 	}
 }
 
 func BindMessageRoutes(router *gin.Engine, messageHandler *MessageHandler) {
 	router.POST("/messages", messageHandler.HandleCreateMessage)
 	router.GET("/rooms/:roomId/messages", messageHandler.HandleGetRoomMessages)
+	router.PATCH("/messages/:id", messageHandler.HandleEditMessage)
+	router.GET("/messages/:id/edits", messageHandler.HandleGetMessageEdits)
+	router.DELETE("/messages/:id", messageHandler.HandleDeleteMessage)
+	router.GET("/messages/:id/thread", messageHandler.HandleGetThread)
+	router.PUT("/messages/:id/reactions/:emoji", messageHandler.HandleAddReaction)
+	router.DELETE("/messages/:id/reactions/:emoji", messageHandler.HandleRemoveReaction)
+	router.GET("/search/messages", messageHandler.HandleSearchMessages)
 }
 
+// checkRoomAccess returns the room's audience and whether the user has every one of permissions in it. Rooms that
+// don't exist can't be accessed.
+func (h *MessageHandler) checkRoomAccess(
+	c *gin.Context,
+	userId uuid.UUID,
+	roomId uuid.UUID,
+	permissions role.RoomPermission,
+) (*room.Audience, bool, error) {
+	audience, userRoles, err := h.getAudience(c, userId, roomId)
+	if audience == nil || err != nil {
+		return nil, false, err
+	}
+	return audience, audience.Can(userId, userRoles, permissions), nil
+}
+
+// getAudience returns the room's audience, or nil if there is no such room, and the user's roles
+func (h *MessageHandler) getAudience(c *gin.Context, userId uuid.UUID, roomId uuid.UUID) (*room.Audience, []string, error) {
+	userRoles, err := h.UserService.GetUserRoles(c.Request.Context(), userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	audience, err := h.RoomService.GetAudience(c, roomId)
+	if errors.Is(err, room.ErrRoomNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return audience, userRoles, nil
+}
+
+// mayMention reports whether the caller may send text: @everyone takes the MentionEveryone permission
+func (h *MessageHandler) mayMention(c *gin.Context, text string) (bool, error) {
+	if !strings.Contains(text, "@everyone") {
+		return true, nil
+	}
+	return role.HasPermission(c, h.RoleService, role.MentionEveryone)
+}
+
+// HandleGetRoomMessages returns a page of a room's top-level messages, newest first.
+// Query parameters: limit, and at most one of before or after (a cursor from a previous page), around (a message ID
+// to center the page on), or the older timestamp (RFC 3339, same as before but only by time).
+// next_cursor is passed as before to page back to older messages and prev_cursor as after to page forward to newer
+// ones; each is left out when there is nothing more in that direction.
 func (h *MessageHandler) HandleGetRoomMessages(c *gin.Context) {
-	roomId := c.Param("roomId")
-	timestampStr := c.Query("timestamp")
-	var cursorTimestamp *time.Time
-	if timestampStr != "" {
+	roomId, err := uuid.Parse(c.Param("roomId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	request, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	// Check if user has permission to view the room
+	_, allowed, err := h.checkRoomAccess(c, userId.(uuid.UUID), roomId, role.RoomView)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	page, err := h.MessageService.GetMessagesForRoom(c, roomId, userId.(uuid.UUID), request)
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"messages": page.Messages}
+	if page.Next != nil {
+		response["next_cursor"] = page.Next.Encode()
+	}
+	if page.Prev != nil {
+		response["prev_cursor"] = page.Prev.Encode()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func parsePageRequest(c *gin.Context) (PageRequest, error) {
+	request := PageRequest{Limit: DefaultPageSize}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > MaxPageSize {
+			return request, errors.New("limit must be between 1 and " + strconv.Itoa(MaxPageSize))
+		}
+		request.Limit = limit
+	}
+
+	positions := 0
+	if beforeStr := c.Query("before"); beforeStr != "" {
+		cursor, err := DecodeCursor(beforeStr)
+		if err != nil {
+			return request, err
+		}
+		request.Before = cursor
+		positions++
+	}
+	if afterStr := c.Query("after"); afterStr != "" {
+		cursor, err := DecodeCursor(afterStr)
+		if err != nil {
+			return request, err
+		}
+		request.After = cursor
+		positions++
+	}
+	if aroundStr := c.Query("around"); aroundStr != "" {
+		around, err := uuid.Parse(aroundStr)
+		if err != nil {
+			return request, errors.New("invalid around message id")
+		}
+		request.Around = &around
+		positions++
+	}
+	if timestampStr := c.Query("timestamp"); timestampStr != "" {
 		parsedTime, err := time.Parse(time.RFC3339, timestampStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid timestamp format"})
-			return
+			return request, errors.New("invalid timestamp format")
 		}
-		cursorTimestamp = &parsedTime
-	} else {
-		cursorTimestamp = nil
+		// No message has an ID below uuid.Nil, so this cursor skips everything at the timestamp itself, as it always has
+		request.Before = &Cursor{Timestamp: parsedTime, ID: uuid.Nil}
+		positions++
+	}
+	if positions > 1 {
+		return request, errors.New("only one of before, after, around and timestamp may be given")
+	}
+	return request, nil
+}
+
+func (h *MessageHandler) HandleCreateMessage(c *gin.Context) {
+	// Get request body
+	var request *model.MessageCreateRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	userId, exists := c.Get("user_id")
@@ -59,38 +214,95 @@ func (h *MessageHandler) HandleGetRoomMessages(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return
 	}
-	// Check if user has permission to view the room
-	userRoles, err := h.UserService.GetUserRoles(c.Request.Context(), userId.(uuid.UUID))
+
+	// Check if user has permission to post in the room, and to attach files if there are any
+	permissions := role.RoomPost
+	if len(request.AttachmentIDs) > 0 {
+		permissions |= role.RoomAttach
+	}
+	audience, allowed, err := h.checkRoomAccess(c, userId.(uuid.UUID), request.RoomID, permissions)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	roomRoles, err := h.RoomService.GetRolesForRoom(c, uuid.MustParse(roomId))
+
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	allowed, err = h.mayMention(c, request.Message)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if !role.HasCommonRole(&userRoles, &roomRoles) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "mentioning @everyone requires the mention_everyone permission"})
 		return
 	}
 
-	message, err := h.MessageService.GetMessagesForRoom(c, uuid.MustParse(roomId), cursorTimestamp)
+	// Done checking if user has permission
+
+	eventType := model.NewMessage
+	if request.ParentID != nil {
+		err = h.MessageService.ValidateThreadParent(c, request.RoomID, *request.ParentID)
+		if errors.Is(err, ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, ErrInvalidThreadParent) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		eventType = model.ThreadReply
+	}
+
+	newRequest := model.MessageCreateRequest{
+		UserID:        userId.(uuid.UUID),
+		RoomID:        request.RoomID,
+		Message:       request.Message,
+		ParentID:      request.ParentID,
+		AttachmentIDs: request.AttachmentIDs,
+	}
+
+	msg, err := h.MessageService.CreateMessage(&newRequest)
+	if errors.Is(err, ErrInvalidAttachment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"messages": message})
+
+	_, err = audience.Publish(c, h.ServerEventStore, eventType, msg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": msg})
 }
 
-func (h *MessageHandler) HandleCreateMessage(c *gin.Context) {
-	// Get request body
-	var request *model.MessageCreateRequest
+func (h *MessageHandler) HandleEditMessage(c *gin.Context) {
+	messageId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
+	var request EditMessageRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if strings.TrimSpace(request.Message) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message is empty"})
+		return
+	}
 
 	userId, exists := c.Get("user_id")
 	if !exists {
@@ -98,42 +310,399 @@ func (h *MessageHandler) HandleCreateMessage(c *gin.Context) {
 		return
 	}
 
-	// Check if user has permission to post in the room
-	userRoles, err := h.UserService.GetUserRoles(c.Request.Context(), userId.(uuid.UUID))
+	existing, err := h.MessageService.GetMessage(c, messageId)
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	roomRoles, err := h.RoomService.GetRolesForRoom(c, request.RoomID)
+	// The author must still be able to post in the room
+	audience, allowed, err := h.checkRoomAccess(c, userId.(uuid.UUID), existing.RoomID, role.RoomPost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
 
+	allowed, err = h.mayMention(c, request.Message)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "mentioning @everyone requires the mention_everyone permission"})
+		return
+	}
 
-	if !role.HasCommonRole(&userRoles, &roomRoles) {
+	msg, err := h.MessageService.EditMessage(c, messageId, userId.(uuid.UUID), request.Message)
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrNotMessageAuthor) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err = audience.Publish(c, h.ServerEventStore, model.MessageEdited, msg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": msg})
+}
+
+func (h *MessageHandler) HandleGetMessageEdits(c *gin.Context) {
+	messageId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	msg, err := h.MessageService.GetMessage(c, messageId)
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, allowed, err := h.checkRoomAccess(c, userId.(uuid.UUID), msg.RoomID, role.RoomView)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
 		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
 		return
 	}
 
-	// Done checking if user has permission
+	edits, err := h.MessageService.GetMessageEdits(c, messageId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"edits": edits})
+}
 
-	newRequest := model.MessageCreateRequest{
-		UserID:  userId.(uuid.UUID),
-		RoomID:  request.RoomID,
-		Message: request.Message,
+func (h *MessageHandler) HandleDeleteMessage(c *gin.Context) {
+	messageId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	msg, err := h.MessageService.CreateMessage(&newRequest)
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	existing, err := h.MessageService.GetMessage(c, messageId)
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	audience, allowed, err := h.checkRoomAccess(c, userId.(uuid.UUID), existing.RoomID, role.RoomView)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	asModerator, err := role.HasPermission(c, h.RoleService, role.DeleteAnyMessage)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	msg, err := h.MessageService.DeleteMessage(c, messageId, userId.(uuid.UUID), asModerator)
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrNotMessageAuthor) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The tombstone is already committed, so a failure here only leaves files behind for a later cleanup
+	err = h.AttachmentService.DeleteForMessage(c, messageId)
+	if err != nil {
+		slog.Error("Failed to delete attachments of deleted message",
+			slog.String("messageId", messageId.String()),
+			slog.String("error", err.Error()),
+		)
+	}
 
-	_, err = h.ServerEventStore.Create(c, model.NewMessage, msg, &roomRoles)
+	_, err = audience.Publish(c, h.ServerEventStore, model.MessageDeleted, msg)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": msg})
 }
+
+// HandleGetThread returns a thread's parent message and a page of its replies, oldest first. Pass next_cursor as after
+// to get the next page; it is left out on the last one. The older timestamp parameter (RFC 3339) still works, but skips
+// replies that share the timestamp.
+func (h *MessageHandler) HandleGetThread(c *gin.Context) {
+	messageId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var after *Cursor
+	if afterStr := c.Query("after"); afterStr != "" {
+		after, err = DecodeCursor(afterStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else if timestampStr := c.Query("timestamp"); timestampStr != "" {
+		parsedTime, err := time.Parse(time.RFC3339Nano, timestampStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid timestamp format"})
+			return
+		}
+		// Everything at the timestamp sorts before uuid.Max, so this is strictly after it as before
+		after = &Cursor{Timestamp: parsedTime, ID: uuid.Max}
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	parent, err := h.MessageService.GetThreadParent(c, messageId, userId.(uuid.UUID))
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if parent.ParentID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message is a reply, not a thread"})
+		return
+	}
+
+	_, allowed, err := h.checkRoomAccess(c, userId.(uuid.UUID), parent.RoomID, role.RoomView)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	replies, next, err := h.MessageService.GetThread(c, messageId, userId.(uuid.UUID), after)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	response := gin.H{"parent": parent, "messages": replies}
+	if next != nil {
+		response["next_cursor"] = next.Encode()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *MessageHandler) HandleAddReaction(c *gin.Context) {
+	h.handleReaction(c, model.ReactionAdded)
+}
+
+func (h *MessageHandler) HandleRemoveReaction(c *gin.Context) {
+	h.handleReaction(c, model.ReactionRemoved)
+}
+
+// handleReaction adds or removes the caller's reaction depending on eventType. Both are idempotent, and an event is
+// only broadcast when something actually changed.
+func (h *MessageHandler) handleReaction(c *gin.Context, eventType model.ServerEventType) {
+	messageId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	emoji := c.Param("emoji")
+	if !ValidEmoji(emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidEmoji.Error()})
+		return
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	msg, err := h.MessageService.GetMessage(c, messageId)
+	if errors.Is(err, ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if msg.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrMessageNotFound.Error()})
+		return
+	}
+
+	audience, allowed, err := h.checkRoomAccess(c, userId.(uuid.UUID), msg.RoomID, role.RoomReact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var changed bool
+	if eventType == model.ReactionAdded {
+		changed, err = h.MessageService.AddReaction(c, messageId, userId.(uuid.UUID), emoji)
+	} else {
+		changed, err = h.MessageService.RemoveReaction(c, messageId, userId.(uuid.UUID), emoji)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	reaction := model.ReactionEvent{
+		MessageID: messageId,
+		RoomID:    msg.RoomID,
+		UserID:    userId.(uuid.UUID),
+		Emoji:     emoji,
+	}
+	if changed {
+		_, err = audience.Publish(c, h.ServerEventStore, eventType, reaction)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"reaction": reaction})
+}
+
+// HandleSearchMessages runs a full-text search over every room the caller can see.
+// Query parameters: q (required), room_id, author_id, before and after (RFC 3339), limit, and cursor from the previous
+// page's next_cursor.
+func (h *MessageHandler) HandleSearchMessages(c *gin.Context) {
+	query := SearchQuery{
+		Query: strings.TrimSpace(c.Query("q")),
+		Limit: DefaultSearchLimit,
+	}
+	if query.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	if roomIdStr := c.Query("room_id"); roomIdStr != "" {
+		roomId, err := uuid.Parse(roomIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid room_id"})
+			return
+		}
+		query.RoomID = &roomId
+	}
+	if authorIdStr := c.Query("author_id"); authorIdStr != "" {
+		authorId, err := uuid.Parse(authorIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid author_id"})
+			return
+		}
+		query.AuthorID = &authorId
+	}
+	if beforeStr := c.Query("before"); beforeStr != "" {
+		before, err := time.Parse(time.RFC3339, beforeStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before timestamp"})
+			return
+		}
+		query.Before = &before
+	}
+	if afterStr := c.Query("after"); afterStr != "" {
+		after, err := time.Parse(time.RFC3339, afterStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid after timestamp"})
+			return
+		}
+		query.After = &after
+	}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > MaxSearchLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(MaxSearchLimit)})
+			return
+		}
+		query.Limit = limit
+	}
+	if cursorStr := c.Query("cursor"); cursorStr != "" {
+		cursor, err := DecodeCursor(cursorStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query.Cursor = cursor
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userRoles, err := h.UserService.GetUserRoles(c.Request.Context(), userId.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, next, err := h.MessageService.SearchMessages(c, query, userId.(uuid.UUID), userRoles)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"results": results}
+	if next != nil {
+		response["next_cursor"] = next.Encode()
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,39 @@
+package message
+
+import (
+	"backend/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type EditMessageRequest struct {
+	Message string `json:"message"`
+}
+
+// MessageEdit is a prior version of a message. EditedAt is when it was replaced.
+type MessageEdit struct {
+	ID        uuid.UUID `json:"id"`
+	MessageID uuid.UUID `json:"message_id"`
+	Message   string    `json:"message"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+// PageRequest selects a page of a room's messages. At most one of Before, After and Around is set; with none of them
+// the page holds the latest messages.
+type PageRequest struct {
+	// Before pages back to older messages, After forward to newer ones. Neither includes the cursor's own message.
+	Before *Cursor
+	After  *Cursor
+	// Around centers the page on a message, which is included
+	Around *uuid.UUID
+	Limit  int
+}
+
+// Page is a page of messages, newest first. Next continues to older messages and Prev to newer ones; each is nil when
+// there is nothing more in that direction.
+type Page struct {
+	Messages []model.Message
+	Next     *Cursor
+	Prev     *Cursor
+}
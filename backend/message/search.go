@@ -0,0 +1,142 @@
+package message
+
+import (
+	"backend/model"
+	"context"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultSearchLimit = 25
+	MaxSearchLimit     = 100
+)
+
+// SearchQuery describes a full-text search. Query uses web search syntax: quoted phrases, "or", and -excluded words.
+// Every other field is an optional filter. Results are newest first, and Cursor continues after a previous page.
+type SearchQuery struct {
+	Query    string
+	RoomID   *uuid.UUID
+	AuthorID *uuid.UUID
+	Before   *time.Time
+	After    *time.Time
+	Cursor   *Cursor
+	Limit    int
+}
+
+// Postgres marks the matches in a snippet with these control characters, which can't be confused with HTML, and
+// highlightSnippet turns them into <mark></mark> once the rest of the text is escaped
+const (
+	snippetStartSel = "\x02"
+	snippetStopSel  = "\x03"
+)
+
+// SearchResult is a matching message. Snippet is an HTML excerpt of its text with the matches wrapped in
+// <mark></mark>; the message text in it is escaped.
+type SearchResult struct {
+	Message model.Message `json:"message"`
+	Snippet string        `json:"snippet"`
+}
+
+// highlightSnippet escapes a snippet from ts_headline and swaps its sentinels for <mark></mark>
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetStartSel, "<mark>")
+	return strings.ReplaceAll(snippet, snippetStopSel, "</mark>")
+}
+
+// SearchMessages returns the messages matching the query in rooms the user may see, along with the cursor of the next
+// page, or nil if this is the last one. Rooms are visible by the same rule as room.Audience: DMs to their participants,
+// and other rooms by role.HasCommonRole, so a room without roles is visible to anyone with at least one role and
+// otherwise the user must share one of its roles.
+func (s *Service) SearchMessages(ctx context.Context, query SearchQuery, userId uuid.UUID, userRoles []string) ([]SearchResult, *Cursor, error) {
+	results := []SearchResult{}
+
+	var cursorTimestamp *time.Time
+	var cursorId *uuid.UUID
+	if query.Cursor != nil {
+		cursorTimestamp = &query.Cursor.Timestamp
+		cursorId = &query.Cursor.ID
+	}
+
+	// Fetch one extra row to find out whether there is another page
+	rows, err := s.DB.Query(ctx,
+		`with q as (select websearch_to_tsquery('english', $1) as query)
+		select `+messageColumns+`,
+			-- The sentinels are taken out of the message first so only the ones ts_headline adds are left
+			ts_headline('english', translate(m.message, $11, ''), q.query,
+				'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxFragments=2, MaxWords=30, MinWords=10')
+		from open_discord.messages m, q
+		where m.search_vector @@ q.query
+			and m.deleted_at is null
+			and ($2::uuid is null or m.room_id = $2)
+			and ($3::uuid is null or m.user_id = $3)
+			and ($4::timestamptz is null or m.timestamp < $4)
+			and ($5::timestamptz is null or m.timestamp > $5)
+			and ($6::timestamptz is null or (m.timestamp, m.id) < ($6, $7::uuid))
+			and case (select kind from open_discord.rooms where id = m.room_id)
+				when 'dm' then exists (
+					select 1 from open_discord.room_participants p where p.room_id = m.room_id and p.user_id = $10
+				)
+				-- The same rule as room.Audience.Includes
+				else cardinality($8::text[]) > 0 and (
+					exists (
+						select 1
+						from open_discord.room_roles rr
+						join open_discord.roles r on r.id = rr.role_id
+						where rr.room_id = m.room_id and rr.allow & 1 = 1 and r.name = any($8)
+					)
+					or (
+						not exists (select 1 from open_discord.room_roles rr where rr.room_id = m.room_id and rr.allow & 1 = 1)
+						and not exists (
+							select 1
+							from open_discord.room_roles rr
+							join open_discord.roles r on r.id = rr.role_id
+							where rr.room_id = m.room_id and rr.deny & 1 = 1 and r.name = any($8)
+						)
+					)
+				)
+			end
+		order by m.timestamp desc, m.id desc
+		limit $9`,
+		query.Query,
+		query.RoomID,
+		query.AuthorID,
+		query.Before,
+		query.After,
+		cursorTimestamp,
+		cursorId,
+		userRoles,
+		query.Limit+1,
+		userId,
+		snippetStartSel+snippetStopSel,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result SearchResult
+		message, err := scanMessage(rows, &result.Snippet)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.Message = *message
+		result.Snippet = highlightSnippet(result.Snippet)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(results) <= query.Limit {
+		return results, nil, nil
+	}
+	results = results[:query.Limit]
+	next := cursorFor(results[len(results)-1].Message)
+	return results, &next, nil
+}
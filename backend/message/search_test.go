@@ -0,0 +1,11 @@
+package message
+
+import "testing"
+
+func TestHighlightSnippetEscapesMessageText(t *testing.T) {
+	snippet := "<script>alert(1)</script> " + snippetStartSel + "cats" + snippetStopSel + " & dogs"
+	want := "&lt;script&gt;alert(1)&lt;/script&gt; <mark>cats</mark> &amp; dogs"
+	if got := highlightSnippet(snippet); got != want {
+		t.Errorf("highlightSnippet() = %q, want %q", got, want)
+	}
+}
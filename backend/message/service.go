@@ -1,15 +1,51 @@
 package message
 
 import (
+	"backend/attachment"
 	"backend/model"
 	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var ErrMessageNotFound = errors.New("message not found")
+var ErrNotMessageAuthor = errors.New("you are not the author of this message")
+var ErrInvalidThreadParent = errors.New("replies must be to a top-level message in the same room")
+var ErrInvalidEmoji = errors.New("invalid emoji")
+var ErrInvalidAttachment = errors.New("attachments must be uploaded to the same room by you and not be on another message")
+
+// MaxEmojiLength is the longest reaction accepted, in bytes. Emoji built from several code points (flags, skin tones,
+// families) are well under this.
+const MaxEmojiLength = 64
+
+// ThreadPageSize is how many replies GetThread returns at once
+const ThreadPageSize = 50
+
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+)
+
+// messageColumns are the columns scanned by scanMessage, in order. Queries alias open_discord.messages as m.
+const messageColumns = `m.id, m.room_id, m.user_id, m.message, m.timestamp, m.edited_at, m.deleted_at, m.parent_id`
+
+// replyStatsJoin adds reply_count and last_reply_at columns summarizing the thread under each m
+const replyStatsJoin = `left join lateral (
+	select count(*) as reply_count, max(r.timestamp) as last_reply_at
+	from open_discord.messages r
+	where r.parent_id = m.id and r.deleted_at is null
+) replies on true`
+
 type Service struct {
 	DB *pgxpool.Pool
 }
@@ -20,43 +56,590 @@ func NewMessageService(db *pgxpool.Pool) *Service {
 	}
 }
 
-func (s *Service) GetMessagesForRoom(c *gin.Context, roomId uuid.UUID, cursorTimestamp *time.Time) (*[]model.Message, error) {
+// scanMessage scans messageColumns, followed by any extra columns selected after them
+func scanMessage(row pgx.Row, extra ...any) (*model.Message, error) {
+	var message model.Message
+	dest := []any{
+		&message.ID,
+		&message.RoomID,
+		&message.UserID,
+		&message.Message,
+		&message.TimeStamp,
+		&message.EditedAt,
+		&message.DeletedAt,
+		&message.ParentID,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// GetMessagesForRoom returns a page of top-level messages, newest first. Messages are ordered by (timestamp, id), so
+// ones sharing a timestamp are never skipped. Reactions are reported from userId's point of view.
+func (s *Service) GetMessagesForRoom(c *gin.Context, roomId uuid.UUID, userId uuid.UUID, request PageRequest) (*Page, error) {
+	page := &Page{}
+	switch {
+	case request.Around != nil:
+		target, err := s.getWithReplyStats(c, *request.Around)
+		if err != nil {
+			return nil, err
+		}
+		if target.RoomID != roomId || target.ParentID != nil {
+			return nil, ErrMessageNotFound
+		}
+		anchor := cursorFor(*target)
+
+		olderLimit := (request.Limit - 1) / 2
+		newerLimit := request.Limit - 1 - olderLimit
+		newer, moreNewer, err := s.getPage(c, roomId, &anchor, false, newerLimit)
+		if err != nil {
+			return nil, err
+		}
+		older, moreOlder, err := s.getPage(c, roomId, &anchor, true, olderLimit)
+		if err != nil {
+			return nil, err
+		}
+		page.Messages = append(append(newer, *target), older...)
+		if moreNewer {
+			page.Prev = firstCursor(page.Messages)
+		}
+		if moreOlder {
+			page.Next = lastCursor(page.Messages)
+		}
+
+	case request.After != nil:
+		messages, more, err := s.getPage(c, roomId, request.After, false, request.Limit)
+		if err != nil {
+			return nil, err
+		}
+		page.Messages = messages
+		// Older messages always exist: at least the one the cursor points at
+		page.Next = request.After
+		if len(messages) > 0 {
+			page.Next = lastCursor(messages)
+		}
+		if more {
+			page.Prev = firstCursor(messages)
+		}
+
+	default:
+		messages, more, err := s.getPage(c, roomId, request.Before, true, request.Limit)
+		if err != nil {
+			return nil, err
+		}
+		page.Messages = messages
+		if more {
+			page.Next = lastCursor(messages)
+		}
+		if request.Before != nil {
+			// Newer messages always exist: at least the one the cursor points at
+			page.Prev = request.Before
+			if len(messages) > 0 {
+				page.Prev = firstCursor(messages)
+			}
+		}
+	}
+
+	if err := s.attachDetails(c, page.Messages, userId); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// getPage returns up to limit top-level messages on one side of the cursor, newest first, and whether there are more
+// beyond them. Without a cursor it starts from the newest (older) or oldest (newer) message in the room.
+func (s *Service) getPage(ctx context.Context, roomId uuid.UUID, cursor *Cursor, older bool, limit int) ([]model.Message, bool, error) {
+	messages := []model.Message{}
+	if limit <= 0 {
+		return messages, false, nil
+	}
 
-	var messages []model.Message
-	rows, err := s.DB.Query(
-		c,
-		`SELECT id, room_id, user_id, message, timestamp FROM open_discord.messages WHERE room_id = $1 AND ($2::timestamp is null or timestamp < $2::timestamp) ORDER BY timestamp DESC limit 25`,
+	comparison, order := ">", "ASC"
+	if older {
+		comparison, order = "<", "DESC"
+	}
+	var cursorTimestamp *time.Time
+	var cursorId *uuid.UUID
+	if cursor != nil {
+		cursorTimestamp = &cursor.Timestamp
+		cursorId = &cursor.ID
+	}
+
+	// Fetch one extra row to find out whether there are more
+	rows, err := s.DB.Query(ctx,
+		`SELECT `+messageColumns+`, replies.reply_count, replies.last_reply_at
+		FROM open_discord.messages m `+replyStatsJoin+`
+		WHERE m.room_id = $1 AND m.parent_id is null
+			AND ($2::timestamptz is null or (m.timestamp, m.id) `+comparison+` ($2::timestamptz, $3::uuid))
+		ORDER BY m.timestamp `+order+`, m.id `+order+`
+		LIMIT $4`,
 		roomId,
 		cursorTimestamp,
+		cursorId,
+		limit+1,
 	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var replyCount int
+		var lastReplyAt *time.Time
+		message, err := scanMessage(rows, &replyCount, &lastReplyAt)
+		if err != nil {
+			return nil, false, err
+		}
+		message.ReplyCount = replyCount
+		message.LastReplyAt = lastReplyAt
+		messages = append(messages, *message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	more := len(messages) > limit
+	if more {
+		messages = messages[:limit]
+	}
+	if !older {
+		slices.Reverse(messages)
+	}
+	return messages, more, nil
+}
+
+func (s *Service) GetMessage(ctx context.Context, messageId uuid.UUID) (*model.Message, error) {
+	message, err := scanMessage(s.DB.QueryRow(ctx,
+		`SELECT `+messageColumns+` FROM open_discord.messages m WHERE m.id = $1`,
+		messageId,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	return message, err
+}
+
+// CreateMessage posts a message, taking ownership of the attachments listed in the request. Attachments must have been
+// uploaded to the same room by the same user and not be on another message, otherwise ErrInvalidAttachment is returned
+// and nothing is posted.
+func (s *Service) CreateMessage(request *model.MessageCreateRequest) (*model.Message, error) {
+	ctx := context.Background()
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	message, err := scanMessage(tx.QueryRow(
+		ctx,
+		`INSERT INTO open_discord.messages AS m (room_id, user_id, message, parent_id) VALUES ($1, $2, $3, $4) RETURNING `+messageColumns,
+		request.RoomID, request.UserID, request.Message, request.ParentID,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	message.Attachments = []model.Attachment{}
+	if len(request.AttachmentIDs) > 0 {
+		attachmentIds := make(map[uuid.UUID]struct{}, len(request.AttachmentIDs))
+		for _, id := range request.AttachmentIDs {
+			attachmentIds[id] = struct{}{}
+		}
+
+		rows, err := tx.Query(ctx,
+			`update open_discord.attachments
+			set message_id = $1
+			where id = any($2) and room_id = $3 and uploader_id = $4 and message_id is null
+			returning `+attachment.Columns,
+			message.ID, request.AttachmentIDs, request.RoomID, request.UserID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			file, err := attachment.ScanAttachment(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			message.Attachments = append(message.Attachments, *file)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		if len(message.Attachments) != len(attachmentIds) {
+			return nil, ErrInvalidAttachment
+		}
+	}
 
+	return message, tx.Commit(ctx)
+}
+
+// ValidateThreadParent checks that a reply posted to roomId can be attached to parentId
+func (s *Service) ValidateThreadParent(ctx context.Context, roomId uuid.UUID, parentId uuid.UUID) error {
+	parent, err := s.GetMessage(ctx, parentId)
+	if err != nil {
+		return err
+	}
+	if parent.RoomID != roomId || parent.ParentID != nil || parent.DeletedAt != nil {
+		return ErrInvalidThreadParent
+	}
+	return nil
+}
+
+// GetThreadParent returns a top-level message along with its reply summary and reactions
+func (s *Service) GetThreadParent(ctx context.Context, messageId uuid.UUID, userId uuid.UUID) (*model.Message, error) {
+	message, err := s.getWithReplyStats(ctx, messageId)
 	if err != nil {
 		return nil, err
 	}
+
+	messages := []model.Message{*message}
+	if err := s.attachDetails(ctx, messages, userId); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+// getWithReplyStats returns a message along with its reply summary
+func (s *Service) getWithReplyStats(ctx context.Context, messageId uuid.UUID) (*model.Message, error) {
+	var replyCount int
+	var lastReplyAt *time.Time
+	message, err := scanMessage(s.DB.QueryRow(ctx,
+		`SELECT `+messageColumns+`, replies.reply_count, replies.last_reply_at
+		FROM open_discord.messages m `+replyStatsJoin+`
+		WHERE m.id = $1`,
+		messageId,
+	), &replyCount, &lastReplyAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	message.ReplyCount = replyCount
+	message.LastReplyAt = lastReplyAt
+	return message, nil
+}
+
+// GetThread returns a page of replies to parentId, oldest first, starting after the cursor if one is given, along
+// with the cursor of the next page, or nil if this is the last one. Reactions are reported from userId's point of view.
+func (s *Service) GetThread(ctx context.Context, parentId uuid.UUID, userId uuid.UUID, after *Cursor) ([]model.Message, *Cursor, error) {
+	var cursorTimestamp *time.Time
+	var cursorId *uuid.UUID
+	if after != nil {
+		cursorTimestamp = &after.Timestamp
+		cursorId = &after.ID
+	}
+
+	// Fetch one extra row to find out whether there is another page
+	rows, err := s.DB.Query(ctx,
+		`SELECT `+messageColumns+`
+		FROM open_discord.messages m
+		WHERE m.parent_id = $1 AND ($2::timestamptz is null or (m.timestamp, m.id) > ($2, $3::uuid))
+		ORDER BY m.timestamp, m.id
+		LIMIT $4`,
+		parentId, cursorTimestamp, cursorId, ThreadPageSize+1,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer rows.Close()
 
+	messages := []model.Message{}
 	for rows.Next() {
-		var message model.Message
-		err := rows.Scan(&message.ID, &message.RoomID, &message.UserID, &message.Message, &message.TimeStamp)
+		message, err := scanMessage(rows)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		messages = append(messages, message)
+		messages = append(messages, *message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	return &messages, nil
+	var next *Cursor
+	if len(messages) > ThreadPageSize {
+		messages = messages[:ThreadPageSize]
+		next = lastCursor(messages)
+	}
+	if err := s.attachDetails(ctx, messages, userId); err != nil {
+		return nil, nil, err
+	}
+	return messages, next, nil
 }
 
-func (s *Service) CreateMessage(request *model.MessageCreateRequest) (*model.Message, error) {
-	var message model.Message
-	err := s.DB.QueryRow(
-		context.Background(),
-		`INSERT INTO open_discord.messages (room_id, user_id, message) VALUES ($1, $2, $3) RETURNING id, room_id, user_id, message, timestamp`,
-		request.RoomID, request.UserID, request.Message,
-	).Scan(&message.ID, &message.RoomID, &message.UserID, &message.Message, &message.TimeStamp)
+// attachDetails fills in the reactions and attachments of each message, in place
+func (s *Service) attachDetails(ctx context.Context, messages []model.Message, userId uuid.UUID) error {
+	if err := s.attachReactions(ctx, messages, userId); err != nil {
+		return err
+	}
+	return s.attachAttachments(ctx, messages)
+}
+
+// attachAttachments fills in the attachments of each message, in place
+func (s *Service) attachAttachments(ctx context.Context, messages []model.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byId := make(map[uuid.UUID]*model.Message, len(messages))
+	ids := make([]uuid.UUID, 0, len(messages))
+	for i := range messages {
+		messages[i].Attachments = []model.Attachment{}
+		byId[messages[i].ID] = &messages[i]
+		ids = append(ids, messages[i].ID)
+	}
+
+	rows, err := s.DB.Query(ctx,
+		`select `+attachment.Columns+` from open_discord.attachments where message_id = any($1) order by created_at`,
+		ids,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		file, err := attachment.ScanAttachment(rows)
+		if err != nil {
+			return err
+		}
+		message := byId[*file.MessageID]
+		message.Attachments = append(message.Attachments, *file)
+	}
+	return rows.Err()
+}
+
+// attachReactions fills in the aggregated reactions of each message, in place
+func (s *Service) attachReactions(ctx context.Context, messages []model.Message, userId uuid.UUID) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byId := make(map[uuid.UUID]*model.Message, len(messages))
+	ids := make([]uuid.UUID, 0, len(messages))
+	for i := range messages {
+		messages[i].Reactions = []model.Reaction{}
+		byId[messages[i].ID] = &messages[i]
+		ids = append(ids, messages[i].ID)
+	}
+
+	rows, err := s.DB.Query(ctx,
+		`select message_id, emoji, count(*), bool_or(user_id = $2)
+		from open_discord.message_reactions
+		where message_id = any($1)
+		group by message_id, emoji
+		order by min(created_at)`,
+		ids, userId,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageId uuid.UUID
+		var reaction model.Reaction
+		err := rows.Scan(&messageId, &reaction.Emoji, &reaction.Count, &reaction.Reacted)
+		if err != nil {
+			return err
+		}
+		message := byId[messageId]
+		message.Reactions = append(message.Reactions, reaction)
+	}
+	return rows.Err()
+}
+
+// ValidEmoji reports whether emoji can be used as a reaction: a short, printable string without whitespace
+func ValidEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > MaxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddReaction reacts to a message on behalf of the user and reports whether the reaction is new. Deleted messages
+// can't be reacted to.
+func (s *Service) AddReaction(ctx context.Context, messageId uuid.UUID, userId uuid.UUID, emoji string) (bool, error) {
+	if !ValidEmoji(emoji) {
+		return false, ErrInvalidEmoji
+	}
+	tag, err := s.DB.Exec(ctx,
+		`insert into open_discord.message_reactions (message_id, user_id, emoji)
+		select id, $2, $3 from open_discord.messages where id = $1 and deleted_at is null
+		on conflict do nothing`,
+		messageId, userId, emoji,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RemoveReaction removes the user's reaction from a message and reports whether there was one
+func (s *Service) RemoveReaction(ctx context.Context, messageId uuid.UUID, userId uuid.UUID, emoji string) (bool, error) {
+	tag, err := s.DB.Exec(ctx,
+		`delete from open_discord.message_reactions where message_id = $1 and user_id = $2 and emoji = $3`,
+		messageId, userId, emoji,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// EditMessage replaces the text of a message, keeping its previous text in open_discord.message_edits.
+// Only the message's author may edit it, and deleted messages can't be edited.
+func (s *Service) EditMessage(ctx context.Context, messageId uuid.UUID, userId uuid.UUID, text string) (*model.Message, error) {
+	tx, err := s.DB.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback(ctx)
 
-	return &message, nil
+	var authorId uuid.UUID
+	var previousText string
+	err = tx.QueryRow(ctx,
+		`select user_id, message from open_discord.messages where id = $1 and deleted_at is null for update`,
+		messageId,
+	).Scan(&authorId, &previousText)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if authorId != userId {
+		slog.Warn("Rejected edit of another user's message",
+			slog.String("messageId", messageId.String()),
+			slog.String("userId", userId.String()),
+		)
+		return nil, ErrNotMessageAuthor
+	}
+
+	_, err = tx.Exec(ctx,
+		`insert into open_discord.message_edits (message_id, message) values ($1, $2)`,
+		messageId, previousText,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := scanMessage(tx.QueryRow(ctx,
+		`update open_discord.messages m set message = $2, edited_at = now() where m.id = $1 returning `+messageColumns,
+		messageId, text,
+	))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	messages := []model.Message{*message}
+	if err := s.attachAttachments(ctx, messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+// DeleteMessage tombstones a message: its text, edit history and reactions are removed but the row stays, so pages of
+// the room don't shift. The events it was sent in are rewritten to the tombstone too. Only the author may delete a message unless asModerator is set.
+func (s *Service) DeleteMessage(ctx context.Context, messageId uuid.UUID, userId uuid.UUID, asModerator bool) (*model.Message, error) {
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var authorId uuid.UUID
+	err = tx.QueryRow(ctx,
+		`select user_id from open_discord.messages where id = $1 and deleted_at is null for update`,
+		messageId,
+	).Scan(&authorId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if authorId != userId && !asModerator {
+		slog.Warn("Rejected deletion of another user's message",
+			slog.String("messageId", messageId.String()),
+			slog.String("userId", userId.String()),
+		)
+		return nil, ErrNotMessageAuthor
+	}
+
+	_, err = tx.Exec(ctx, `delete from open_discord.message_edits where message_id = $1`, messageId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `delete from open_discord.message_reactions where message_id = $1`, messageId)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := scanMessage(tx.QueryRow(ctx,
+		`update open_discord.messages m set message = '', deleted_at = now(), deleted_by = $2 where m.id = $1 returning `+messageColumns,
+		messageId, userId,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	// The events that carried the message still have its text for GET /events and replays, so they get the tombstone
+	tombstone, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx,
+		`update open_discord.server_events set payload = $2
+		where event_type in ('new_message', 'thread_reply', 'message_edited') and payload ->> 'id' = $1::text`,
+		messageId, string(tombstone),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Deleted message",
+		slog.String("messageId", messageId.String()),
+		slog.String("deletedBy", userId.String()),
+	)
+	return message, tx.Commit(ctx)
+}
+
+// GetMessageEdits returns the prior versions of a message, oldest first
+func (s *Service) GetMessageEdits(ctx context.Context, messageId uuid.UUID) ([]MessageEdit, error) {
+	rows, err := s.DB.Query(ctx,
+		`select id, message_id, message, edited_at from open_discord.message_edits where message_id = $1 order by edited_at`,
+		messageId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []MessageEdit{}
+	for rows.Next() {
+		var edit MessageEdit
+		err := rows.Scan(&edit.ID, &edit.MessageID, &edit.Message, &edit.EditedAt)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	return edits, rows.Err()
 }
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Attachment is an uploaded file. MessageID is nil until the attachment is posted with a message.
+type Attachment struct {
+	ID          uuid.UUID  `json:"id"`
+	RoomID      uuid.UUID  `json:"room_id"`
+	UploaderID  uuid.UUID  `json:"uploader_id"`
+	MessageID   *uuid.UUID `json:"message_id"`
+	Filename    string     `json:"filename"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	CreatedAt   time.Time  `json:"created_at"`
+	// Width, Height and Blurhash are set once an image has been processed, along with its thumbnail
+	Width        *int    `json:"width"`
+	Height       *int    `json:"height"`
+	Blurhash     *string `json:"blurhash"`
+	HasThumbnail bool    `json:"has_thumbnail"`
+}
@@ -10,6 +10,10 @@ type MessageCreateRequest struct {
 	UserID  uuid.UUID `json:"user_id"`
 	RoomID  uuid.UUID `json:"room_id"`
 	Message string    `json:"message"`
+	// ParentID makes the message a thread reply to a top-level message in the same room
+	ParentID *uuid.UUID `json:"parent_id,omitempty"`
+	// AttachmentIDs are uploads to the same room, by the same user, that aren't on a message yet
+	AttachmentIDs []uuid.UUID `json:"attachment_ids,omitempty"`
 }
 
 type ServerEventType string
@@ -20,22 +24,73 @@ const (
 	UserLeft    ServerEventType = "user_left"
 	RoomCreated ServerEventType = "room_created"
 	RoomDeleted ServerEventType = "room_deleted"
+	RoomRenamed ServerEventType = "room_renamed"
+	// MessageEdited carries the full updated Message
+	MessageEdited ServerEventType = "message_edited"
+	// MessageDeleted carries the deleted Message's tombstone
+	MessageDeleted ServerEventType = "message_deleted"
+	// ThreadReply carries a new reply Message, kept apart from NewMessage so it stays out of the room timeline
+	ThreadReply ServerEventType = "thread_reply"
+	// ReactionAdded and ReactionRemoved carry a ReactionEvent
+	ReactionAdded   ServerEventType = "reaction_added"
+	ReactionRemoved ServerEventType = "reaction_removed"
+	// Redacted stands in for an event the receiving user isn't allowed to see, so their sequence stays gap-free
+	Redacted ServerEventType = "redacted"
+	// ResyncRequired is the last event on a connection that fell too far behind. The client should reconnect with
+	// Last-Event-ID to replay what it missed.
+	ResyncRequired ServerEventType = "resync_required"
 )
 
+// ServerEvent is the envelope for everything pushed to clients over SSE.
+// Persisted events carry the ID and ServerEventOrder of their row in open_discord.server_events, and the order doubles
+// as the SSE event id so clients can resume with Last-Event-ID. Ephemeral events (user presence) are never persisted
+// and have an order of 0.
 type ServerEvent struct {
-	ServerEventType ServerEventType `json:"server_event_type"`
-	ServerEventTime time.Time       `json:"server_event_time"`
-	Payload         any             `json:"payload"`
-	ClientMessageId int             `json:"client_message_id,omitempty"`
-	Roles           *[]string       `json:"roles,omitempty"`
+	ServerEventID    uuid.UUID       `json:"server_event_id,omitzero"`
+	ServerEventOrder int64           `json:"server_event_order,omitempty"`
+	ServerEventType  ServerEventType `json:"server_event_type"`
+	ServerEventTime  time.Time       `json:"server_event_time"`
+	Payload          any             `json:"payload"`
+	Roles            *[]string       `json:"roles,omitempty"`
+	// DeniedRoles hides an event without Roles from users with any of these roles
+	DeniedRoles *[]string `json:"denied_roles,omitempty"`
+	// UserIDs addresses the event to exactly these users, regardless of Roles. It is set for events about DMs.
+	UserIDs *[]uuid.UUID `json:"user_ids,omitempty"`
+}
+
+// Redact returns a placeholder for the event that keeps only its sequence number and time
+func (e ServerEvent) Redact() ServerEvent {
+	return ServerEvent{
+		ServerEventType:  Redacted,
+		ServerEventOrder: e.ServerEventOrder,
+		ServerEventTime:  e.ServerEventTime,
+	}
 }
 
 type Message struct {
-	UserID    uuid.UUID `json:"user_id"`
-	RoomID    uuid.UUID `json:"room_id"`
-	Message   string    `json:"message"`
-	TimeStamp time.Time `json:"timestamp"`
-	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	RoomID    uuid.UUID  `json:"room_id"`
+	Message   string     `json:"message"`
+	TimeStamp time.Time  `json:"timestamp"`
+	ID        uuid.UUID  `json:"id"`
+	EditedAt  *time.Time `json:"edited_at"`
+	// DeletedAt is set on tombstones of deleted messages, whose text is always empty
+	DeletedAt *time.Time `json:"deleted_at"`
+	ParentID  *uuid.UUID `json:"parent_id"`
+	// ReplyCount and LastReplyAt summarize a top-level message's thread
+	ReplyCount  int        `json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at"`
+	// Reactions are aggregated per emoji, in the order each emoji was first used
+	Reactions   []Reaction   `json:"reactions"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Reaction counts the users who reacted to a message with an emoji. Reacted is whether the requesting user is one of
+// them.
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
 }
 
 // UserConnectionEvent Applicable to either UserJoined or UserLeft event types
@@ -45,8 +100,16 @@ type UserConnectionEvent struct {
 	Nickname string    `json:"nickname"`
 }
 
-// RoomExistenceEvent Applicable to either RoomCreated or RoomDeleted event types
+// RoomExistenceEvent Applicable to RoomDeleted or RoomRenamed event types. For a rename, RoomName is the new name.
 type RoomExistenceEvent struct {
 	RoomID   uuid.UUID `json:"room_id"`
 	RoomName string    `json:"room_name"`
 }
+
+// ReactionEvent Applicable to either ReactionAdded or ReactionRemoved event types
+type ReactionEvent struct {
+	MessageID uuid.UUID `json:"message_id"`
+	RoomID    uuid.UUID `json:"room_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Emoji     string    `json:"emoji"`
+}
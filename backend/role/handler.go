@@ -0,0 +1,170 @@
+package role
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleHandler struct {
+	RoleService *Service
+}
+
+func NewRoleHandler(roleService *Service) *RoleHandler {
+	return &RoleHandler{
+		RoleService: roleService,
+	}
+}
+
+// BindRoleRoutes binds the role management API. Besides ManageRoles, every change is checked against the caller's
+// place in the hierarchy; see Actor.
+func BindRoleRoutes(router *gin.Engine, handler *RoleHandler) {
+	requireManageRoles := RequirePermission(handler.RoleService, ManageRoles)
+	router.GET("/roles", handler.HandleGetRoles)
+	router.POST("/roles", requireManageRoles, handler.HandleCreateRole)
+	router.PATCH("/roles/:roleName", requireManageRoles, handler.HandleUpdateRole)
+	router.DELETE("/roles/:roleName", requireManageRoles, handler.HandleDeleteRole)
+}
+
+func (h *RoleHandler) HandleGetRoles(c *gin.Context) {
+	roles, err := h.RoleService.GetAllRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+func (h *RoleHandler) HandleCreateRole(c *gin.Context) {
+	var request CreateRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if request.Position != nil && *request.Position < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position can't be negative"})
+		return
+	}
+
+	actor, err := GetActor(c, h.RoleService)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	proposed := Role{Permissions: request.Permissions, Position: 1}
+	if request.Position != nil {
+		proposed.Position = *request.Position
+	}
+	if err := actor.CanGrant(proposed); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := h.RoleService.Create(c, request)
+	if errors.Is(err, ErrRoleExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"role": role})
+}
+
+// HandleUpdateRole changes a role's permissions or position. Both the role as it is and as it would be must be within
+// what the caller can grant.
+func (h *RoleHandler) HandleUpdateRole(c *gin.Context) {
+	var request UpdateRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Position != nil && *request.Position < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position can't be negative"})
+		return
+	}
+
+	actor, err := GetActor(c, h.RoleService)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	existing, err := h.RoleService.GetRole(c, c.Param("roleName"))
+	if errors.Is(err, ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	proposed := *existing
+	if request.Permissions != nil {
+		proposed.Permissions = *request.Permissions
+	}
+	if request.Position != nil {
+		proposed.Position = *request.Position
+	}
+	err = actor.CanManage(*existing)
+	if err == nil {
+		err = actor.CanGrant(proposed)
+	}
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := h.RoleService.Update(c, existing.Name, request)
+	if errors.Is(err, ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": role})
+}
+
+func (h *RoleHandler) HandleDeleteRole(c *gin.Context) {
+	actor, err := GetActor(c, h.RoleService)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	existing, err := h.RoleService.GetRole(c, c.Param("roleName"))
+	if errors.Is(err, ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := actor.CanManage(*existing); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.RoleService.DeleteRole(existing.Name)
+	if errors.Is(err, ErrProtectedRole) || errors.Is(err, ErrRoleInUse) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
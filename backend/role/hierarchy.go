@@ -0,0 +1,49 @@
+package role
+
+import "errors"
+
+var (
+	ErrRoleTooHigh = errors.New("role is not ranked below your highest role")
+	ErrUserTooHigh = errors.New("user has a role that is not ranked below your highest role")
+	ErrEscalation  = errors.New("role has permissions you don't have")
+)
+
+// NoPosition is the position of a user without roles, below every role
+const NoPosition = -1
+
+// Actor is a user managing roles: the position of their highest role and every permission their roles grant. An
+// actor can only manage roles ranked below their highest role, can't hand out permissions they don't have, and can't
+// change the roles of users ranked as high as them. Together these keep anyone from escalating themselves, or granting
+// anything they couldn't do themselves.
+type Actor struct {
+	Position    int
+	Permissions Permission
+}
+
+// CanManage checks that the actor may change or delete the role, or take it away from someone
+func (a Actor) CanManage(role Role) error {
+	if role.Position >= a.Position {
+		return ErrRoleTooHigh
+	}
+	return nil
+}
+
+// CanGrant checks that the actor may give the role to someone, or create or change a role to look like it
+func (a Actor) CanGrant(role Role) error {
+	if err := a.CanManage(role); err != nil {
+		return err
+	}
+	if !a.Permissions.Has(role.Permissions) {
+		return ErrEscalation
+	}
+	return nil
+}
+
+// CanManageUser checks that the actor may change the roles of a user whose highest role is at position. Actors may
+// always manage their own roles, within what CanManage and CanGrant allow.
+func (a Actor) CanManageUser(position int, self bool) error {
+	if !self && position >= a.Position {
+		return ErrUserTooHigh
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package role
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestActorCanGrant(t *testing.T) {
+	moderator := Actor{Position: 100, Permissions: DeleteAnyMessage | ManageRoles}
+
+	tests := []struct {
+		name string
+		role Role
+		want error
+	}{
+		{"lower role", Role{Position: 1, Permissions: DeleteAnyMessage}, nil},
+		{"no permissions", Role{Position: 0}, nil},
+		{"same position", Role{Position: 100}, ErrRoleTooHigh},
+		{"admin", Role{Position: 1000, Permissions: -1}, ErrRoleTooHigh},
+		{"lower role with more permissions", Role{Position: 1, Permissions: MintOTC}, ErrEscalation},
+	}
+	for _, test := range tests {
+		if err := moderator.CanGrant(test.role); !errors.Is(err, test.want) {
+			t.Errorf("%v: CanGrant() = %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	// Taking away a lower role is fine even if the caller doesn't have its permissions
+	if err := moderator.CanManage(Role{Position: 1, Permissions: MintOTC}); err != nil {
+		t.Errorf("CanManage() = %v, want nil", err)
+	}
+}
+
+func TestActorCanManageUser(t *testing.T) {
+	moderator := Actor{Position: 100, Permissions: ManageRoles}
+	if err := moderator.CanManageUser(0, false); err != nil {
+		t.Errorf("CanManageUser() of a lower user = %v", err)
+	}
+	if err := moderator.CanManageUser(100, false); !errors.Is(err, ErrUserTooHigh) {
+		t.Errorf("CanManageUser() of a peer = %v, want %v", err, ErrUserTooHigh)
+	}
+	if err := moderator.CanManageUser(100, true); err != nil {
+		t.Errorf("CanManageUser() of themselves = %v", err)
+	}
+
+	// A user without roles ranks below everything, even default at position 0
+	nobody := Actor{Position: NoPosition}
+	if err := nobody.CanManage(Role{Position: 0}); !errors.Is(err, ErrRoleTooHigh) {
+		t.Errorf("CanManage() without roles = %v, want %v", err, ErrRoleTooHigh)
+	}
+}
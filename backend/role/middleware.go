@@ -0,0 +1,65 @@
+package role
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission aborts the request with 403 unless one of the caller's roles grants permission. It reads the
+// roles that AuthMiddleware put on the context.
+func RequirePermission(service *Service, permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, err := HasPermission(c, service, permission)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasPermission reports whether one of the caller's roles grants permission, for handlers that only need it in some
+// cases. The caller's permissions are looked up once per request.
+func HasPermission(c *gin.Context, service *Service, permission Permission) (bool, error) {
+	if cached, exists := c.Get("user_permissions"); exists {
+		return cached.(Permission).Has(permission), nil
+	}
+	permissions, err := service.PermissionsFor(c.Request.Context(), c.GetStringSlice("user_roles"))
+	if err != nil {
+		return false, err
+	}
+	c.Set("user_permissions", permissions)
+	return permissions.Has(permission), nil
+}
+
+// GetActor returns the caller as an Actor, from the roles that AuthMiddleware put on the context
+func GetActor(c *gin.Context, service *Service) (Actor, error) {
+	return service.ActorFor(c.Request.Context(), c.GetStringSlice("user_roles"))
+}
+
+// CanGrantRoles checks that the caller may give every one of the named roles to someone, as when minting an OTC that
+// comes with them. It returns ErrRoleNotFound for a role that doesn't exist.
+func CanGrantRoles(c *gin.Context, service *Service, roleNames []string) error {
+	if len(roleNames) == 0 {
+		return nil
+	}
+	actor, err := GetActor(c, service)
+	if err != nil {
+		return err
+	}
+	for _, roleName := range roleNames {
+		granted, err := service.GetRole(c, roleName)
+		if err != nil {
+			return err
+		}
+		if err := actor.CanGrant(*granted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,6 +3,22 @@ package role
 import "github.com/google/uuid"
 
 type Role struct {
-	ID uuid.UUID `json:"id"`
-	Name string `json:"name"`
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Permissions Permission `json:"permissions"`
+	// Position ranks the role, higher first. See Actor.
+	Position int `json:"position"`
+}
+
+type CreateRoleRequest struct {
+	Name        string     `json:"name"`
+	Permissions Permission `json:"permissions"`
+	// Position defaults to just above default
+	Position *int `json:"position"`
+}
+
+// UpdateRoleRequest changes whichever of the role's permissions and position are set
+type UpdateRoleRequest struct {
+	Permissions *Permission `json:"permissions"`
+	Position    *int        `json:"position"`
 }
@@ -0,0 +1,144 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Permission is a bitset of what members of a role may do across the server. A user has every permission granted by
+// any of their roles. The values are stored in roles.permissions, so existing bits must never be renumbered.
+type Permission int64
+
+const (
+	CreateRoom Permission = 1 << iota
+	DeleteRoom
+	// ManageRooms covers renaming and reordering rooms
+	ManageRooms
+	// ManageRoles covers role permissions and which roles can view or post in a room
+	ManageRoles
+	DeleteAnyMessage
+	MintOTC
+	MentionEveryone
+	ViewStats
+	// Administer covers the /admin API, which can do anything the CLI can, though still within the role hierarchy
+	Administer
+)
+
+// RoomPermission is a bitset of what a user may do in a room. A room role allows and denies some of them for its
+// members, stored in room_roles.allow and room_roles.deny, so existing bits must never be renumbered.
+type RoomPermission int64
+
+const (
+	RoomView RoomPermission = 1 << iota
+	RoomPost
+	RoomReact
+	RoomAttach
+
+	AllRoomPermissions = RoomView | RoomPost | RoomReact | RoomAttach
+)
+
+var ErrUnknownPermission = errors.New("unknown permission")
+
+type named[T ~int64] struct {
+	bit  T
+	name string
+}
+
+var permissionNames = []named[Permission]{
+	{CreateRoom, "create_room"},
+	{DeleteRoom, "delete_room"},
+	{ManageRooms, "manage_rooms"},
+	{ManageRoles, "manage_roles"},
+	{DeleteAnyMessage, "delete_any_message"},
+	{MintOTC, "mint_otc"},
+	{MentionEveryone, "mention_everyone"},
+	{ViewStats, "view_stats"},
+	{Administer, "administer"},
+}
+
+var roomPermissionNames = []named[RoomPermission]{
+	{RoomView, "view"},
+	{RoomPost, "post"},
+	{RoomReact, "react"},
+	{RoomAttach, "attach"},
+}
+
+func bitNames[T ~int64](set T, table []named[T]) []string {
+	names := []string{}
+	for _, entry := range table {
+		if set&entry.bit != 0 {
+			names = append(names, entry.name)
+		}
+	}
+	return names
+}
+
+func parseBits[T ~int64](names []string, table []named[T]) (T, error) {
+	var set T
+	for _, name := range names {
+		index := slices.IndexFunc(table, func(entry named[T]) bool { return entry.name == name })
+		if index < 0 {
+			return 0, fmt.Errorf("%w: %v", ErrUnknownPermission, name)
+		}
+		set |= table[index].bit
+	}
+	return set, nil
+}
+
+// Has reports whether every bit of permission is set
+func (p Permission) Has(permission Permission) bool {
+	return p&permission == permission
+}
+
+// Names lists the permissions in the set, ignoring bits that have no name
+func (p Permission) Names() []string {
+	return bitNames(p, permissionNames)
+}
+
+// ParsePermissions turns permission names such as "create_room" into a set
+func ParsePermissions(names []string) (Permission, error) {
+	return parseBits(names, permissionNames)
+}
+
+func (p Permission) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Names())
+}
+
+func (p *Permission) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	parsed, err := ParsePermissions(names)
+	*p = parsed
+	return err
+}
+
+func (p RoomPermission) Has(permission RoomPermission) bool {
+	return p&permission == permission
+}
+
+func (p RoomPermission) Names() []string {
+	return bitNames(p, roomPermissionNames)
+}
+
+// ParseRoomPermissions turns names such as "view" and "post" into a set
+func ParseRoomPermissions(names []string) (RoomPermission, error) {
+	return parseBits(names, roomPermissionNames)
+}
+
+func (p RoomPermission) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Names())
+}
+
+func (p *RoomPermission) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	parsed, err := ParseRoomPermissions(names)
+	*p = parsed
+	return err
+}
@@ -0,0 +1,54 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestPermissionNames(t *testing.T) {
+	permissions, err := ParsePermissions([]string{"mint_otc", "create_room"})
+	if err != nil {
+		t.Fatalf("ParsePermissions() error = %v", err)
+	}
+	if permissions != CreateRoom|MintOTC {
+		t.Errorf("ParsePermissions() = %b", permissions)
+	}
+	if got := permissions.Names(); !slices.Equal(got, []string{"create_room", "mint_otc"}) {
+		t.Errorf("Names() = %v", got)
+	}
+
+	if _, err := ParsePermissions([]string{"fly"}); !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("ParsePermissions() error = %v, want %v", err, ErrUnknownPermission)
+	}
+}
+
+func TestPermissionHas(t *testing.T) {
+	// Admins are stored as -1 so they get every permission, including ones added later
+	admin := Permission(-1)
+	if !admin.Has(ViewStats) || !admin.Has(CreateRoom|DeleteAnyMessage) {
+		t.Error("all bits set should have every permission")
+	}
+	if (CreateRoom | DeleteRoom).Has(CreateRoom | ManageRoles) {
+		t.Error("Has() should require every bit")
+	}
+}
+
+func TestPermissionJSON(t *testing.T) {
+	asJson, err := json.Marshal(Role{Name: "moderator", Permissions: DeleteAnyMessage, Position: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":"00000000-0000-0000-0000-000000000000","name":"moderator","permissions":["delete_any_message"],"position":100}`; string(asJson) != want {
+		t.Errorf("json = %s, want %s", asJson, want)
+	}
+
+	var roomPermissions RoomPermission
+	if err := json.Unmarshal([]byte(`["post","attach"]`), &roomPermissions); err != nil {
+		t.Fatal(err)
+	}
+	if roomPermissions != RoomPost|RoomAttach {
+		t.Errorf("room permissions = %v, want post and attach", roomPermissions.Names())
+	}
+}
@@ -1,31 +1,81 @@
 package role
 
 import (
+	"backend/model"
 	"context"
+	"errors"
+	"slices"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var ErrRoleNotFound = errors.New("role not found")
+var ErrRoleExists = errors.New("role already exists")
+var ErrProtectedRole = errors.New("admin and default roles can't be renamed or deleted")
+var ErrRoleInUse = errors.New("role is still assigned to users")
+
+const roleColumns = "id, name, permissions, position"
+
 type Service struct {
 	DB *pgxpool.Pool
 }
 
 func (s Service) CreateRole(name string) (*Role, error) {
+	return s.Create(context.Background(), CreateRoleRequest{Name: name})
+}
+
+func (s Service) Create(ctx context.Context, request CreateRoleRequest) (*Role, error) {
+	var exists bool
+	err := s.DB.QueryRow(ctx, "select exists (select 1 from open_discord.roles where name = $1)", request.Name).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrRoleExists
+	}
+
 	var role Role
-	err := s.DB.QueryRow(context.Background(), "insert into open_discord.roles(name) values ($1) returning id, name", name).Scan(&role.ID, &role.Name)
+	err = s.DB.QueryRow(ctx,
+		`insert into open_discord.roles (name, permissions, position) values ($1, $2, coalesce($3, 1))
+		returning `+roleColumns,
+		request.Name, request.Permissions, request.Position,
+	).Scan(&role.ID, &role.Name, &role.Permissions, &role.Position)
 	if err != nil {
 		return nil, err
 	}
 	return &role, nil
 }
 
+// DeleteRole deletes the named role, which must not be assigned to anyone. The protected roles from migration 0004
+// can't be deleted.
 func (s Service) DeleteRole(name string) error {
-	_, err := s.DB.Exec(context.Background(), "delete from open_discord.roles where name = $1", name)
-	return err
+	tag, err := s.DB.Exec(context.Background(), "delete from open_discord.roles where name = $1", name)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		// raise_exception, from the protect_system_roles trigger
+		case "P0001":
+			return ErrProtectedRole
+		// foreign_key_violation, from user_roles
+		case "23503":
+			return ErrRoleInUse
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
 }
 
+// GetAllRoles returns every role, highest position first
 func (s Service) GetAllRoles() ([]Role, error) {
-	rows, err := s.DB.Query(context.Background(), "select id, name from open_discord.roles")
+	rows, err := s.DB.Query(context.Background(), "select "+roleColumns+" from open_discord.roles order by position desc, name")
 	if err != nil {
 		return nil, err
 	}
@@ -33,22 +83,89 @@ func (s Service) GetAllRoles() ([]Role, error) {
 	var roles []Role
 	for rows.Next() {
 		var role Role
-		err := rows.Scan(&role.ID, &role.Name)
+		err := rows.Scan(&role.ID, &role.Name, &role.Permissions, &role.Position)
 		if err != nil {
 			return nil, err
 		}
 		roles = append(roles, role)
 	}
-	return roles, nil
+	return roles, rows.Err()
 }
 
-func IsAdmin(roles []string) bool {
-	for _, role := range roles {
-		if role == "admin" {
-			return true
-		}
+func (s Service) GetRole(ctx context.Context, name string) (*Role, error) {
+	var role Role
+	err := s.DB.QueryRow(ctx, "select "+roleColumns+" from open_discord.roles where name = $1", name).
+		Scan(&role.ID, &role.Name, &role.Permissions, &role.Position)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRoleNotFound
 	}
-	return false
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// SetPermissions replaces the permissions of the named role
+func (s Service) SetPermissions(ctx context.Context, name string, permissions Permission) (*Role, error) {
+	return s.Update(ctx, name, UpdateRoleRequest{Permissions: &permissions})
+}
+
+// Update changes whichever of the named role's permissions and position are set in request
+func (s Service) Update(ctx context.Context, name string, request UpdateRoleRequest) (*Role, error) {
+	var role Role
+	err := s.DB.QueryRow(ctx,
+		`update open_discord.roles
+		set permissions = coalesce($2, permissions), position = coalesce($3, position)
+		where name = $1
+		returning `+roleColumns,
+		name, request.Permissions, request.Position,
+	).Scan(&role.ID, &role.Name, &role.Permissions, &role.Position)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// ActorFor returns the Actor for a user with the named roles
+func (s Service) ActorFor(ctx context.Context, roleNames []string) (Actor, error) {
+	actor := Actor{Position: NoPosition}
+	if len(roleNames) == 0 {
+		return actor, nil
+	}
+	err := s.DB.QueryRow(ctx,
+		"select coalesce(max(position), $2), coalesce(bit_or(permissions), 0) from open_discord.roles where name = any($1)",
+		roleNames, NoPosition,
+	).Scan(&actor.Position, &actor.Permissions)
+	return actor, err
+}
+
+// PositionOf returns the position of the user's highest role, or NoPosition if they have none
+func (s Service) PositionOf(ctx context.Context, userId uuid.UUID) (int, error) {
+	var position int
+	err := s.DB.QueryRow(ctx,
+		`select coalesce(max(r.position), $2)
+		from open_discord.user_roles ur
+		join open_discord.roles r on r.id = ur.role_id
+		where ur.user_id = $1`,
+		userId, NoPosition,
+	).Scan(&position)
+	return position, err
+}
+
+// PermissionsFor returns every permission granted by any of the named roles
+func (s Service) PermissionsFor(ctx context.Context, roleNames []string) (Permission, error) {
+	if len(roleNames) == 0 {
+		return 0, nil
+	}
+	var permissions Permission
+	err := s.DB.QueryRow(ctx,
+		"select coalesce(bit_or(permissions), 0) from open_discord.roles where name = any($1)",
+		roleNames,
+	).Scan(&permissions)
+	return permissions, err
 }
 
 func HasCommonRole(userRoles, roomRoles *[]string) bool {
@@ -69,3 +186,19 @@ func HasCommonRole(userRoles, roomRoles *[]string) bool {
 	}
 	return false
 }
+
+// CanSeeEvent reports whether a user with userRoles may see the event. Events addressed to users are visible to exactly
+// those users; anything else follows HasCommonRole, except that events without roles are hidden from DeniedRoles.
+func CanSeeEvent(event model.ServerEvent, userId uuid.UUID, userRoles []string) bool {
+	if event.UserIDs != nil {
+		return slices.Contains(*event.UserIDs, userId)
+	}
+	if event.Roles == nil || len(*event.Roles) == 0 {
+		if event.DeniedRoles != nil && slices.ContainsFunc(userRoles, func(role string) bool {
+			return slices.Contains(*event.DeniedRoles, role)
+		}) {
+			return false
+		}
+	}
+	return HasCommonRole(&userRoles, event.Roles)
+}
@@ -0,0 +1,98 @@
+package room
+
+import (
+	"backend/model"
+	"backend/role"
+	"backend/serverevent"
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// Audience is who can do what in a room: the participants of a DM can do anything, and everyone else goes by the
+// room's role overrides. A room where no role is allowed to view is open to anyone with a role; otherwise only the
+// roles allowed to view get in. On top of that, the user's roles allow and deny permissions, and when they disagree
+// allow wins, so an announcement room can deny post to default and allow it to staff.
+type Audience struct {
+	Kind         string
+	Roles        []RoomRole
+	Participants []uuid.UUID
+}
+
+// Permissions returns what a user with userRoles may do in the room. Nothing is allowed without RoomView.
+func (a *Audience) Permissions(userId uuid.UUID, userRoles []string) role.RoomPermission {
+	if a.Kind == KindDM {
+		if slices.Contains(a.Participants, userId) {
+			return role.AllRoomPermissions
+		}
+		return 0
+	}
+	if len(userRoles) == 0 {
+		return 0
+	}
+
+	permissions := role.AllRoomPermissions
+	if len(a.ViewRoles()) > 0 {
+		permissions = 0
+	}
+	var allow, deny role.RoomPermission
+	for _, roomRole := range a.Roles {
+		if slices.Contains(userRoles, roomRole.Name) {
+			allow |= roomRole.Allow
+			deny |= roomRole.Deny
+		}
+	}
+	permissions = permissions&^deny | allow
+	if !permissions.Has(role.RoomView) {
+		return 0
+	}
+	return permissions
+}
+
+// Can reports whether a user with userRoles has every one of permissions in the room
+func (a *Audience) Can(userId uuid.UUID, userRoles []string, permissions role.RoomPermission) bool {
+	return a.Permissions(userId, userRoles).Has(permissions)
+}
+
+// Includes reports whether a user with userRoles can see the room
+func (a *Audience) Includes(userId uuid.UUID, userRoles []string) bool {
+	return a.Can(userId, userRoles, role.RoomView)
+}
+
+// ViewRoles returns the roles allowed to view the room, which is empty for a public room
+func (a *Audience) ViewRoles() []string {
+	return a.rolesWhere(func(roomRole RoomRole) bool { return roomRole.Allow.Has(role.RoomView) })
+}
+
+// DeniedViewRoles returns the roles denied viewing the room, which only matters if no role is allowed to view it
+func (a *Audience) DeniedViewRoles() []string {
+	return a.rolesWhere(func(roomRole RoomRole) bool { return roomRole.Deny.Has(role.RoomView) })
+}
+
+func (a *Audience) rolesWhere(match func(RoomRole) bool) []string {
+	names := []string{}
+	for _, roomRole := range a.Roles {
+		if match(roomRole) {
+			names = append(names, roomRole.Name)
+		}
+	}
+	return names
+}
+
+// Publish persists an event about the room and fans it out. Everyone who can't see the room receives it redacted.
+func (a *Audience) Publish(
+	ctx context.Context,
+	store *serverevent.ServerEventStore,
+	eventType model.ServerEventType,
+	payload any,
+) (*model.ServerEvent, error) {
+	if a.Kind == KindDM {
+		return store.CreateForUsers(ctx, eventType, payload, a.Participants)
+	}
+	viewRoles := a.ViewRoles()
+	if deniedRoles := a.DeniedViewRoles(); len(viewRoles) == 0 && len(deniedRoles) > 0 {
+		return store.CreateExcluding(ctx, eventType, payload, &viewRoles, deniedRoles)
+	}
+	return store.Create(ctx, eventType, payload, &viewRoles)
+}
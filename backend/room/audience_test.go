@@ -0,0 +1,96 @@
+package room
+
+import (
+	"backend/role"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSortParticipants(t *testing.T) {
+	a := uuid.MustParse("00000000-0000-0000-0000-000000000001")
+	b := uuid.MustParse("80000000-0000-0000-0000-000000000000")
+	c := uuid.MustParse("ffffffff-0000-0000-0000-000000000000")
+
+	got := SortParticipants([]uuid.UUID{c, a, b, a})
+	if want := []uuid.UUID{a, b, c}; !slices.Equal(got, want) {
+		t.Errorf("SortParticipants() = %v, want %v", got, want)
+	}
+}
+
+func TestAudienceIncludes(t *testing.T) {
+	participant := uuid.New()
+	outsider := uuid.New()
+
+	dm := Audience{Kind: KindDM, Participants: []uuid.UUID{participant}}
+	if !dm.Includes(participant, nil) {
+		t.Error("DM should include its participant even without roles")
+	}
+	// DMs have no room roles, which would make an ordinary room public
+	if dm.Includes(outsider, []string{"default", "admin"}) {
+		t.Error("DM should not include other users, whatever their roles")
+	}
+
+	room := Audience{Kind: KindRoom, Roles: []RoomRole{{Name: "staff", Allow: role.AllRoomPermissions}}}
+	if !room.Includes(outsider, []string{"staff"}) || room.Includes(participant, []string{"default"}) {
+		t.Error("rooms should be gated by role")
+	}
+}
+
+func TestAudiencePermissions(t *testing.T) {
+	user := uuid.New()
+	all := role.AllRoomPermissions
+
+	tests := []struct {
+		name      string
+		roles     []RoomRole
+		userRoles []string
+		want      role.RoomPermission
+	}{
+		{"public", nil, []string{"default"}, all},
+		{"public without a role", nil, nil, 0},
+		{
+			"announcements",
+			[]RoomRole{{Name: "default", Deny: role.RoomPost | role.RoomAttach}, {Name: "staff", Allow: role.RoomPost | role.RoomAttach}},
+			[]string{"default"},
+			role.RoomView | role.RoomReact,
+		},
+		{
+			"announcements as staff",
+			[]RoomRole{{Name: "default", Deny: role.RoomPost | role.RoomAttach}, {Name: "staff", Allow: role.RoomPost | role.RoomAttach}},
+			[]string{"default", "staff"},
+			all,
+		},
+		{"denied view", []RoomRole{{Name: "guest", Deny: role.RoomView}}, []string{"guest"}, 0},
+		{"denied view of another role", []RoomRole{{Name: "guest", Deny: role.RoomView}}, []string{"default"}, all},
+		{"private", []RoomRole{{Name: "staff", Allow: all}}, []string{"default"}, 0},
+		{
+			"private read-only",
+			[]RoomRole{{Name: "staff", Allow: all}, {Name: "default", Allow: role.RoomView}},
+			[]string{"default"},
+			role.RoomView,
+		},
+		{"post without view", []RoomRole{{Name: "staff", Allow: all}, {Name: "bot", Allow: role.RoomPost}}, []string{"bot"}, 0},
+	}
+	for _, test := range tests {
+		audience := Audience{Kind: KindRoom, Roles: test.roles}
+		if got := audience.Permissions(user, test.userRoles); got != test.want {
+			t.Errorf("%v: Permissions() = %v, want %v", test.name, got.Names(), test.want.Names())
+		}
+	}
+}
+
+func TestAudienceViewRoles(t *testing.T) {
+	audience := Audience{Kind: KindRoom, Roles: []RoomRole{
+		{Name: "staff", Allow: role.AllRoomPermissions},
+		{Name: "muted", Deny: role.RoomPost},
+		{Name: "guest", Deny: role.RoomView},
+	}}
+	if got := audience.ViewRoles(); !slices.Equal(got, []string{"staff"}) {
+		t.Errorf("ViewRoles() = %v, want [staff]", got)
+	}
+	if got := audience.DeniedViewRoles(); !slices.Equal(got, []string{"guest"}) {
+		t.Errorf("DeniedViewRoles() = %v, want [guest]", got)
+	}
+}
@@ -3,40 +3,68 @@ package room
 import (
 	"backend/logic"
 	"backend/model"
+	"backend/role"
 	"backend/serverevent"
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// AttachmentDeleter deletes the attachments of a room along with their files. It is attachment.Service, which imports
+// this package.
+type AttachmentDeleter interface {
+	DeleteForRoom(ctx context.Context, roomId uuid.UUID) error
+}
+
 type RoomHandler struct {
-	RoomService      *RoomService
-	Rooms            *map[uuid.UUID]*logic.Room
-	ClientRegistry   *logic.ClientRegistry
-	ServerEventStore *serverevent.ServerEventStore
+	RoomService       *RoomService
+	RoleService       *role.Service
+	Rooms             *logic.Rooms
+	ClientRegistry    *logic.ClientRegistry
+	ServerEventStore  *serverevent.ServerEventStore
+	AttachmentDeleter AttachmentDeleter
 }
 
 func NewRoomHandler(
 	roomService *RoomService,
-	Rooms *map[uuid.UUID]*logic.Room,
+	roleService *role.Service,
+	Rooms *logic.Rooms,
 	ClientRegistry *logic.ClientRegistry,
 	serverEventStore *serverevent.ServerEventStore,
+	attachmentDeleter AttachmentDeleter,
 ) *RoomHandler {
 	return &RoomHandler{
-		RoomService:      roomService,
-		Rooms:            Rooms,
-		ClientRegistry:   ClientRegistry,
-		ServerEventStore: serverEventStore,
+		RoomService:       roomService,
+		RoleService:       roleService,
+		Rooms:             Rooms,
+		ClientRegistry:    ClientRegistry,
+		ServerEventStore:  serverEventStore,
+		AttachmentDeleter: attachmentDeleter,
 	}
 }
 
 func BindRoomRoutes(router *gin.Engine, RoomHandler *RoomHandler) {
-	router.POST("/rooms", RoomHandler.HandleCreateRoom)
+	requirePermission := func(permission role.Permission) gin.HandlerFunc {
+		return role.RequirePermission(RoomHandler.RoleService, permission)
+	}
+	router.POST("/rooms", requirePermission(role.CreateRoom), RoomHandler.HandleCreateRoom)
 	router.GET("/rooms", RoomHandler.HandleGetAllRooms)
-	router.PUT("/rooms/order", RoomHandler.HandleSwapRoomOrder)
+	router.PUT("/rooms/order", requirePermission(role.ManageRooms), RoomHandler.HandleSwapRoomOrder)
 	router.PUT("/rooms/:roomId/star", RoomHandler.HandleStarRoom)
 	router.DELETE("/rooms/:roomId/star", RoomHandler.HandleStarRoom)
+	router.DELETE("/rooms/:roomId", requirePermission(role.DeleteRoom), RoomHandler.HandleDeleteRoom)
+	router.PATCH("/rooms/:roomId", requirePermission(role.ManageRooms), RoomHandler.HandleRenameRoom)
+	router.GET("/rooms/:roomId/roles", requirePermission(role.ManageRoles), RoomHandler.HandleGetRoomRoles)
+	router.PUT("/rooms/:roomId/roles/:roleName", requirePermission(role.ManageRoles), RoomHandler.HandleSetRoomRole)
+	router.DELETE("/rooms/:roomId/roles/:roleName", requirePermission(role.ManageRoles), RoomHandler.HandleRemoveRoomRole)
+	router.POST("/dms", RoomHandler.HandleCreateDM)
 }
 
 func (h *RoomHandler) HandleCreateRoom(c *gin.Context) {
@@ -47,28 +75,263 @@ func (h *RoomHandler) HandleCreateRoom(c *gin.Context) {
 		return
 	}
 
-	newRoom, err := h.RoomService.Create(c.Request.Context(), request)
+	newRoom, err := h.CreateRoom(c.Request.Context(), request)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	(*h.Rooms)[newRoom.ID] = &logic.Room{
+	c.JSON(http.StatusCreated, newRoom)
+}
+
+// CreateRoom creates the room and tells everyone about it. It is shared with the CLI, which has no gin context.
+func (h *RoomHandler) CreateRoom(ctx context.Context, request CreateRoomRequest) (*Room, error) {
+	newRoom, err := h.RoomService.Create(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	h.Rooms.Add(&logic.Room{
 		ClientRegistry: h.ClientRegistry,
 		RoomID:         newRoom.ID,
 		Name:           newRoom.Name,
+	})
+
+	// This should be in the service layer, alas
+	_, err = h.ServerEventStore.Create(ctx, model.RoomCreated, newRoom, nil)
+	if err != nil {
+		return nil, err
 	}
+	return newRoom, nil
+}
 
-	roomCreatedEvent := model.ServerEvent{
-		ServerEventType: model.RoomCreated,
-		Payload:         newRoom.Name,
+func (h *RoomHandler) HandleDeleteRoom(c *gin.Context) {
+	roomUuid, err := uuid.Parse(c.Param("roomId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	h.ServerEventStore.Create(c, model.RoomCreated, newRoom, nil)
-	// This should be in the service layer, alas
-	h.ClientRegistry.FanOutMessage(roomCreatedEvent, nil)
+	deletedRoom, err := h.DeleteRoom(c.Request.Context(), roomUuid)
+	if errors.Is(err, ErrRoomNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusCreated, newRoom)
+	c.JSON(http.StatusOK, deletedRoom)
+}
+
+// DeleteRoom deletes the room and tells everyone who could see it. It is shared with the CLI.
+func (h *RoomHandler) DeleteRoom(ctx context.Context, roomId uuid.UUID) (*Room, error) {
+	// Fetch the audience before deleting, since the room roles and participants go with the room
+	audience, err := h.RoomService.GetAudience(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	// The attachment rows would go with the room too, leaving their files behind
+	err = h.AttachmentDeleter.DeleteForRoom(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	deletedRoom, err := h.RoomService.Delete(ctx, roomId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h.Rooms.Remove(deletedRoom.ID)
+
+	_, err = audience.Publish(ctx, h.ServerEventStore, model.RoomDeleted, model.RoomExistenceEvent{
+		RoomID:   deletedRoom.ID,
+		RoomName: deletedRoom.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deletedRoom, nil
+}
+
+func (h *RoomHandler) HandleRenameRoom(c *gin.Context) {
+	roomUuid, err := uuid.Parse(c.Param("roomId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request RenameRoomRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is empty"})
+		return
+	}
+
+	audience, err := h.RoomService.GetAudience(c, roomUuid)
+	if errors.Is(err, ErrRoomNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if audience.Kind == KindDM {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "DMs can't be renamed"})
+		return
+	}
+
+	renamedRoom, err := h.RoomService.Rename(c.Request.Context(), roomUuid, request.Name)
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+		return
+	}
+	// 23505 is unique_violation
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		c.JSON(http.StatusConflict, gin.H{"error": "a room with that name already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.Rooms.Rename(renamedRoom.ID, renamedRoom.Name)
+
+	_, err = audience.Publish(c, h.ServerEventStore, model.RoomRenamed, model.RoomExistenceEvent{
+		RoomID:   renamedRoom.ID,
+		RoomName: renamedRoom.Name,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, renamedRoom)
+}
+
+func (h *RoomHandler) HandleGetRoomRoles(c *gin.Context) {
+	roomUuid, err := uuid.Parse(c.Param("roomId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	roomRoles, err := h.RoomService.GetRoomRoles(c, roomUuid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roomRoles})
+}
+
+// HandleSetRoomRole sets what a role is allowed and denied in the room, e.g. {"allow": ["view", "react"]} for
+// read-only access to a private room, or {"deny": ["post"]} to mute the role in a public one.
+func (h *RoomHandler) HandleSetRoomRole(c *gin.Context) {
+	roomUuid, err := uuid.Parse(c.Param("roomId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request SetRoomRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.RoomService.SetRoomRole(c, roomUuid, c.Param("roleName"), request.Allow, request.Deny)
+	if errors.Is(err, ErrConflictingOverride) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrRoomNotFound) || errors.Is(err, role.ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": RoomRole{Name: c.Param("roleName"), Allow: request.Allow, Deny: request.Deny}})
+}
+
+func (h *RoomHandler) HandleRemoveRoomRole(c *gin.Context) {
+	roomUuid, err := uuid.Parse(c.Param("roomId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.RoomService.RemoveRoomRoleByID(c, roomUuid, c.Param("roleName"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+// HandleCreateDM opens a DM between the caller and user_ids. Asking again for the same set of users returns the
+// existing DM with 200 instead of 201.
+func (h *RoomHandler) HandleCreateDM(c *gin.Context) {
+	var request CreateDMRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	participants := SortParticipants(append(request.UserIDs, userId.(uuid.UUID)))
+	if len(participants) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a DM needs at least one other user"})
+		return
+	}
+	if len(participants) > MaxDMParticipants {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a DM can have at most " + strconv.Itoa(MaxDMParticipants) + " users"})
+		return
+	}
+
+	dm, created, err := h.RoomService.CreateDM(c.Request.Context(), participants)
+	if errors.Is(err, ErrUnknownUser) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !created {
+		c.JSON(http.StatusOK, dm)
+		return
+	}
+
+	h.Rooms.Add(&logic.Room{
+		ClientRegistry: h.ClientRegistry,
+		RoomID:         dm.ID,
+		Name:           dm.Name,
+	})
+
+	_, err = h.ServerEventStore.CreateForUsers(c, model.RoomCreated, dm, dm.Participants)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, dm)
 }
 
 func (h *RoomHandler) HandleSwapRoomOrder(c *gin.Context) {
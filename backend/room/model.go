@@ -1,18 +1,56 @@
 package room
 
-import "github.com/google/uuid"
+import (
+	"backend/role"
+
+	"github.com/google/uuid"
+)
+
+const (
+	KindRoom = "room"
+	// KindDM is a direct message conversation. DMs have participants instead of room roles, and their names are
+	// generated, so clients should show the participants instead.
+	KindDM = "dm"
+)
+
+// MaxDMParticipants is the most users a group DM can have, including its creator
+const MaxDMParticipants = 10
 
 type Room struct {
 	ID        uuid.UUID `json:"id"`
 	Name      string    `json:"name"`
 	SortOrder int       `json:"sort_order"`
 	Starred   bool      `json:"starred"`
+	Kind      string    `json:"kind"`
+	// Participants is only set for DMs
+	Participants []uuid.UUID `json:"participants,omitempty"`
 }
 
 type CreateRoomRequest struct {
 	Name string `json:"name"`
 }
 
+type RenameRoomRequest struct {
+	Name string `json:"name"`
+}
+
 type SwapRoomOrderRequest struct {
 	RoomIDs []uuid.UUID `json:"room_ids"`
 }
+
+// CreateDMRequest lists the users to start a DM with. The creator is always added, so it may be left out.
+type CreateDMRequest struct {
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+// RoomRole overrides what members of a role may do in a room. See Audience for how overrides combine.
+type RoomRole struct {
+	Name  string              `json:"name"`
+	Allow role.RoomPermission `json:"allow"`
+	Deny  role.RoomPermission `json:"deny"`
+}
+
+type SetRoomRoleRequest struct {
+	Allow role.RoomPermission `json:"allow"`
+	Deny  role.RoomPermission `json:"deny"`
+}
@@ -1,16 +1,27 @@
 package room
 
 import (
+	"backend/role"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
+var ErrRoomNotFound = errors.New("room not found")
+var ErrUnknownUser = errors.New("unknown user")
+var ErrConflictingOverride = errors.New("a room role can't both allow and deny the same permission")
+
 type RoomService struct {
 	DB          *pgxpool.Pool
 	RedisClient *redis.Client
@@ -43,9 +54,9 @@ func (s RoomService) Create(ctx context.Context, request CreateRoomRequest) (*Ro
 	err = tx.QueryRow(ctx,
 		`INSERT INTO open_discord.rooms (name)
 		 VALUES ($1)
-		 RETURNING id, name, sort_order`,
+		 RETURNING id, name, sort_order, kind`,
 		request.Name,
-	).Scan(&room.ID, &room.Name, &room.SortOrder)
+	).Scan(&room.ID, &room.Name, &room.SortOrder, &room.Kind)
 
 	// Fetch and assign default role to room
 	slog.Info("Assigning default role to room",
@@ -71,7 +82,8 @@ func (s RoomService) Create(ctx context.Context, request CreateRoomRequest) (*Ro
 }
 
 // GetAll returns all rooms along with whether the calling user has starred them. If there is no calling user,
-// then userId will be null and we will mark all rooms as false (for the purposes of system calls)
+// then userId will be null and we will mark all rooms as false (for the purposes of system calls). DMs are only
+// returned to their participants, or to system calls.
 func (s RoomService) GetAll(ctx context.Context, userId *uuid.UUID) ([]Room, error) {
 	var rooms []Room
 	var sql string
@@ -79,19 +91,35 @@ func (s RoomService) GetAll(ctx context.Context, userId *uuid.UUID) ([]Room, err
 	var err error
 
 	if userId == nil {
-		sql = `select id, name, sort_order, false as starred from open_discord.rooms`
+		sql = `select r.id, r.name, r.sort_order, false as starred, r.kind, ` + participantsColumn + `
+				from open_discord.rooms r`
 		rows, err = s.DB.Query(ctx, sql)
 	} else {
-		sql = `SELECT DISTINCT r.id, r.name, r.sort_order,
-                urs.user_id IS NOT NULL AS starred
+		// The same rule as Audience.Includes: a role allowed to view gets in, and a room no role is allowed to view
+		// is open to anyone not denied it
+		sql = `SELECT r.id, r.name, r.sort_order,
+                urs.user_id IS NOT NULL AS starred, r.kind, ` + participantsColumn + `
 				FROM open_discord.rooms r
-					LEFT JOIN open_discord.room_roles rr ON rr.room_id = r.id
-					LEFT JOIN open_discord.user_roles ur ON ur.role_id = rr.role_id
-														AND ur.user_id = $1
 					LEFT JOIN open_discord.user_room_stars urs ON urs.room_id = r.id
 															AND urs.user_id = $1
-				WHERE ur.user_id IS NOT NULL  -- user has access via a role
-				OR rr.room_id IS NULL      -- room has no roles attached (public)
+				WHERE (r.kind = 'room' AND (
+					EXISTS (
+						SELECT 1 FROM open_discord.room_roles rr
+							JOIN open_discord.user_roles ur ON ur.role_id = rr.role_id AND ur.user_id = $1
+						WHERE rr.room_id = r.id AND rr.allow & 1 = 1
+					)
+					OR (
+						NOT EXISTS (SELECT 1 FROM open_discord.room_roles rr WHERE rr.room_id = r.id AND rr.allow & 1 = 1)
+						AND NOT EXISTS (
+							SELECT 1 FROM open_discord.room_roles rr
+								JOIN open_discord.user_roles ur ON ur.role_id = rr.role_id AND ur.user_id = $1
+							WHERE rr.room_id = r.id AND rr.deny & 1 = 1
+						)
+					)
+				))
+				OR (r.kind = 'dm' AND EXISTS (
+					SELECT 1 FROM open_discord.room_participants p WHERE p.room_id = r.id AND p.user_id = $1
+				))
 				ORDER BY r.sort_order`
 		rows, err = s.DB.Query(ctx, sql, *userId)
 	}
@@ -108,7 +136,7 @@ func (s RoomService) GetAll(ctx context.Context, userId *uuid.UUID) ([]Room, err
 
 	for hasNext {
 		var room Room
-		err := rows.Scan(&room.ID, &room.Name, &room.SortOrder, &room.Starred)
+		err := rows.Scan(&room.ID, &room.Name, &room.SortOrder, &room.Starred, &room.Kind, &room.Participants)
 		if err != nil {
 			return nil, err
 		}
@@ -119,6 +147,111 @@ func (s RoomService) GetAll(ctx context.Context, userId *uuid.UUID) ([]Room, err
 	return rooms, nil
 }
 
+// participantsColumn selects a DM's participants, ordered by ID, or null for other rooms
+const participantsColumn = `case when r.kind = 'dm' then array(
+	select p.user_id from open_discord.room_participants p where p.room_id = r.id order by p.user_id
+) end as participants`
+
+// CreateDM returns the DM between exactly participants, creating it if there isn't one yet, and reports whether it
+// was created. participants must include the creator.
+func (s RoomService) CreateDM(ctx context.Context, participants []uuid.UUID) (*Room, bool, error) {
+	participants = SortParticipants(participants)
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serialize creation per participant set so two simultaneous requests can't both create the same DM
+	key := make([]string, len(participants))
+	for i, participant := range participants {
+		key[i] = participant.String()
+	}
+	_, err = tx.Exec(ctx, `select pg_advisory_xact_lock(hashtext('dm:' || $1))`, strings.Join(key, ","))
+	if err != nil {
+		return nil, false, err
+	}
+
+	room := Room{Participants: participants}
+	err = tx.QueryRow(ctx,
+		`select r.id, r.name, r.sort_order, r.kind
+		from open_discord.rooms r
+		join open_discord.room_participants p on p.room_id = r.id
+		where r.kind = 'dm'
+		group by r.id
+		having array_agg(p.user_id order by p.user_id) = $1::uuid[]`,
+		participants,
+	).Scan(&room.ID, &room.Name, &room.SortOrder, &room.Kind)
+	if err == nil {
+		return &room, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+
+	slog.Info("Creating new DM", slog.Int("participants", len(participants)))
+
+	// Room names are unique and required, so DMs get one that can't clash with a real room
+	roomId := uuid.New()
+	err = tx.QueryRow(ctx,
+		`insert into open_discord.rooms (id, name, kind)
+		values ($1, $2, 'dm')
+		returning id, name, sort_order, kind`,
+		roomId, "dm:"+roomId.String(),
+	).Scan(&room.ID, &room.Name, &room.SortOrder, &room.Kind)
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`insert into open_discord.room_participants (room_id, user_id) select $1, unnest($2::uuid[])`,
+		room.ID, participants,
+	)
+	var pgErr *pgconn.PgError
+	// 23503 is foreign_key_violation
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return nil, false, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &room, true, tx.Commit(ctx)
+}
+
+// SortParticipants returns the distinct user IDs in the order Postgres sorts uuids
+func SortParticipants(userIds []uuid.UUID) []uuid.UUID {
+	sorted := slices.Clone(userIds)
+	slices.SortFunc(sorted, func(a, b uuid.UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return slices.Compact(sorted)
+}
+
+// GetAudience returns who can do what in the room: its participants if it is a DM and its role overrides otherwise
+func (s RoomService) GetAudience(ctx context.Context, roomId uuid.UUID) (*Audience, error) {
+	var audience Audience
+	err := s.DB.QueryRow(ctx,
+		`select r.kind, `+participantsColumn+` from open_discord.rooms r where r.id = $1`,
+		roomId,
+	).Scan(&audience.Kind, &audience.Participants)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if audience.Kind == KindDM {
+		return &audience, nil
+	}
+
+	audience.Roles, err = s.GetRoomRoles(ctx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	return &audience, nil
+}
+
 func (s RoomService) Reorder(ctx context.Context, req SwapRoomOrderRequest) error {
 	tx, err := s.DB.Begin(ctx)
 	if err != nil {
@@ -171,44 +304,130 @@ func (s RoomService) Unstar(ctx context.Context, userUuid uuid.UUID, roomUuid uu
 	return nil
 }
 
+// GetByName returns the room with that name. DMs are not included, since their names are generated.
+func (s RoomService) GetByName(ctx context.Context, name string) (*Room, error) {
+	var room Room
+	err := s.DB.QueryRow(ctx,
+		`select id, name, sort_order, kind from open_discord.rooms where name = $1 and kind = 'room'`,
+		name,
+	).Scan(&room.ID, &room.Name, &room.SortOrder, &room.Kind)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// Delete removes the room. Its messages, stars and room roles are removed along with it by the foreign key cascades.
+func (s RoomService) Delete(ctx context.Context, roomId uuid.UUID) (*Room, error) {
+	slog.Info("Deleting room", slog.String("roomId", roomId.String()))
+
+	var room Room
+	err := s.DB.QueryRow(ctx,
+		`delete from open_discord.rooms where id = $1 returning id, name, sort_order, kind`,
+		roomId,
+	).Scan(&room.ID, &room.Name, &room.SortOrder, &room.Kind)
+	if err != nil {
+		slog.Warn("Failed to delete room",
+			slog.String("roomId", roomId.String()),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+
+	s.evictRoomRoles(ctx, roomId)
+	return &room, nil
+}
+
+func (s RoomService) Rename(ctx context.Context, roomId uuid.UUID, name string) (*Room, error) {
+	slog.Info("Renaming room",
+		slog.String("roomId", roomId.String()),
+		slog.String("room name", name),
+	)
+
+	var room Room
+	err := s.DB.QueryRow(ctx,
+		`update open_discord.rooms set name = $2 where id = $1 returning id, name, sort_order, kind`,
+		roomId, name,
+	).Scan(&room.ID, &room.Name, &room.SortOrder, &room.Kind)
+	if err != nil {
+		slog.Warn("Failed to rename room",
+			slog.String("roomId", roomId.String()),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+	return &room, nil
+}
+
 func roomRoleRedisKey(roomId uuid.UUID) string {
-	return "room_roles:" + roomId.String()
+	return "room_overrides:" + roomId.String()
 }
 
-func (s RoomService) GetRolesForRoom(ctx context.Context, roomId uuid.UUID) ([]string, error) {
+// GetRoomRoles returns the room's role overrides
+func (s RoomService) GetRoomRoles(ctx context.Context, roomId uuid.UUID) ([]RoomRole, error) {
 	// Check Redis first
 	redisKey := roomRoleRedisKey(roomId)
-	cachedRoles, err := s.RedisClient.Get(ctx, redisKey).Result()
+	cachedRoles, err := s.RedisClient.Get(ctx, redisKey).Bytes()
 	if err == nil {
-		slog.Debug("Cache hit for room roles", slog.String("room_id", roomId.String()))
-		return strings.Split(cachedRoles, ","), nil
-	} else {
-		slog.Debug("Cache miss for room roles", slog.String("room_id", roomId.String()))
-		slog.Error(err.Error())
+		var roomRoles []RoomRole
+		if err := json.Unmarshal(cachedRoles, &roomRoles); err == nil {
+			slog.Debug("Cache hit for room roles", slog.String("room_id", roomId.String()))
+			return roomRoles, nil
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		slog.Error("Error reading room roles cache",
+			slog.String("room_id", roomId.String()),
+			slog.String("error", err.Error()),
+		)
 	}
+	slog.Debug("Cache miss for room roles", slog.String("room_id", roomId.String()))
 
 	// Fetch from DB
-	var roles []string
-	rows, err := s.DB.Query(ctx, `select r.name from open_discord.roles r join open_discord.room_roles rr on r.id = rr.role_id where rr.room_id = $1`, roomId)
+	roomRoles := []RoomRole{}
+	rows, err := s.DB.Query(ctx,
+		`select r.name, rr.allow, rr.deny
+		from open_discord.roles r
+		join open_discord.room_roles rr on r.id = rr.role_id
+		where rr.room_id = $1`,
+		roomId,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var roleName string
-		err = rows.Scan(&roleName)
+		var roomRole RoomRole
+		err = rows.Scan(&roomRole.Name, &roomRole.Allow, &roomRole.Deny)
 		if err != nil {
 			return nil, err
 		}
-		roles = append(roles, roleName)
+		roomRoles = append(roomRoles, roomRole)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	asJson, err := json.Marshal(roomRoles)
+	if err == nil {
+		err = s.RedisClient.Set(ctx, redisKey, asJson, 5*time.Minute).Err()
+	}
+	if err != nil {
+		slog.Error("Error caching room roles", slog.String("room_id", roomId.String()), slog.String("error", err.Error()))
 	}
-	return roles, nil
+	return roomRoles, nil
 }
 
-func (s RoomService) AssignRoomRole(ctx context.Context, roomName, roleName string) error {
+// AssignRoomRole sets the role's overrides in the room, replacing any it had. Rooms and roles are named since this is
+// usually used by a human.
+func (s RoomService) AssignRoomRole(ctx context.Context, roomName, roleName string, allow, deny role.RoomPermission) error {
 	var roomId uuid.UUID
 	err := s.DB.QueryRow(ctx, `select id from open_discord.rooms r where r.name = $1`, roomName).Scan(&roomId)
-
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrRoomNotFound
+	}
 	if err != nil {
 		slog.Warn("Failed to find room for assigning room role",
 			slog.String("roomName", roomName),
@@ -217,39 +436,53 @@ func (s RoomService) AssignRoomRole(ctx context.Context, roomName, roleName stri
 		)
 		return err
 	}
+	return s.SetRoomRole(ctx, roomId, roleName, allow, deny)
+}
 
-	var roldId uuid.UUID
-	err = s.DB.QueryRow(ctx, `select id from open_discord.roles r where r.name = $1`, roleName).Scan(&roldId)
+// SetRoomRole sets the role's overrides in the room, replacing any it had
+func (s RoomService) SetRoomRole(ctx context.Context, roomId uuid.UUID, roleName string, allow, deny role.RoomPermission) error {
+	if allow&deny != 0 {
+		return ErrConflictingOverride
+	}
 
+	var roleId uuid.UUID
+	err := s.DB.QueryRow(ctx, `select id from open_discord.roles r where r.name = $1`, roleName).Scan(&roleId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return role.ErrRoleNotFound
+	}
 	if err != nil {
 		slog.Warn("Failed to find role for assigning room role",
-			slog.String("roomName", roomName),
+			slog.String("roomId", roomId.String()),
 			slog.String("roleName", roleName),
 			slog.String("error", err.Error()),
 		)
 		return err
 	}
 
-	_, err = s.DB.Exec(ctx, `insert into open_discord.room_roles (room_id, role_id) values ($1, $2)`, roomId, roldId)
+	_, err = s.DB.Exec(ctx,
+		`insert into open_discord.room_roles (room_id, role_id, allow, deny) values ($1, $2, $3, $4)
+		on conflict (room_id, role_id) do update set allow = excluded.allow, deny = excluded.deny`,
+		roomId, roleId, allow, deny,
+	)
+	var pgErr *pgconn.PgError
+	// 23503 is foreign_key_violation
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return ErrRoomNotFound
+	}
 	if err != nil {
 		return err
 	}
 
-	// Evict the cache for the room's roles since we've made a change
-	redisKey := roomRoleRedisKey(roomId)
-	err = s.RedisClient.Del(ctx, redisKey).Err()
-	if err != nil {
-		slog.Error(
-			"Error invalidating room roles cache",
-			slog.String("room_id", roomId.String()),
-		)
-	}
+	s.evictRoomRoles(ctx, roomId)
 	return nil
 }
 
 func (s RoomService) RemoveRoomRole(ctx context.Context, roomName, roleName string) error {
 	var roomId uuid.UUID
 	err := s.DB.QueryRow(ctx, `select id from open_discord.rooms r where r.name = $1`, roomName).Scan(&roomId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrRoomNotFound
+	}
 	if err != nil {
 		slog.Warn("Failed to find room for removing room role",
 			slog.String("roomName", roomName),
@@ -258,35 +491,37 @@ func (s RoomService) RemoveRoomRole(ctx context.Context, roomName, roleName stri
 		)
 		return err
 	}
+	return s.RemoveRoomRoleByID(ctx, roomId, roleName)
+}
 
-	var roldId uuid.UUID
-	err = s.DB.QueryRow(ctx, `select id from open_discord.roles r where r.name = $1`, roleName).Scan(&roldId)
-	if err != nil {
-		slog.Warn("Failed to find role for removing room role",
-			slog.String("roomName", roomName),
-			slog.String("roleName", roleName),
-			slog.String("error", err.Error()),
-		)
-		return err
-	}
-	_, err = s.DB.Exec(ctx, `delete from open_discord.room_roles where room_id = $1 and role_id = $2`, roomId, roldId)
+// RemoveRoomRoleByID drops the role's overrides in the room. If no role is left allowed to view, the room becomes public.
+func (s RoomService) RemoveRoomRoleByID(ctx context.Context, roomId uuid.UUID, roleName string) error {
+	_, err := s.DB.Exec(ctx,
+		`delete from open_discord.room_roles rr
+		using open_discord.roles r
+		where rr.role_id = r.id and rr.room_id = $1 and r.name = $2`,
+		roomId, roleName,
+	)
 	if err != nil {
 		slog.Warn("Failed to remove room role",
-			slog.String("roomName", roomName),
+			slog.String("roomId", roomId.String()),
 			slog.String("roleName", roleName),
 			slog.String("error", err.Error()),
 		)
 		return err
 	}
 
-	// Evict the cache for the room's roles since we've made a change
-	redisKey := roomRoleRedisKey(roomId)
-	err = s.RedisClient.Del(ctx, redisKey).Err()
+	s.evictRoomRoles(ctx, roomId)
+	return nil
+}
+
+// evictRoomRoles evicts the cache for the room's roles since we've made a change
+func (s RoomService) evictRoomRoles(ctx context.Context, roomId uuid.UUID) {
+	err := s.RedisClient.Del(ctx, roomRoleRedisKey(roomId)).Err()
 	if err != nil {
 		slog.Error(
 			"Error invalidating room roles cache",
 			slog.String("room_id", roomId.String()),
 		)
 	}
-	return nil
 }
@@ -0,0 +1,74 @@
+package serverevent
+
+import (
+	"backend/model"
+	"backend/role"
+	"backend/user"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ServerEventHandler struct {
+	ServerEventStore *ServerEventStore
+	UserService      *user.UserService
+}
+
+func NewServerEventHandler(serverEventStore *ServerEventStore, userService *user.UserService) *ServerEventHandler {
+	return &ServerEventHandler{
+		ServerEventStore: serverEventStore,
+		UserService:      userService,
+	}
+}
+
+func BindServerEventRoutes(router *gin.Engine, handler *ServerEventHandler) {
+	router.GET("/events", handler.HandleGetServerEvents)
+}
+
+// HandleGetServerEvents lets a client gap-fill a range of the event sequence without reconnecting its SSE stream.
+func (h *ServerEventHandler) HandleGetServerEvents(c *gin.Context) {
+	start, err := strconv.ParseInt(c.DefaultQuery("event_order_start", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid event_order_start"})
+		return
+	}
+
+	var end *int64
+	if endStr := c.Query("event_order_end"); endStr != "" {
+		parsedEnd, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid event_order_end"})
+			return
+		}
+		end = &parsedEnd
+	}
+
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	userRoles, err := h.UserService.GetUserRoles(c.Request.Context(), userId.(uuid.UUID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, err := h.ServerEventStore.GetEventsInRange(c, start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	visible := make([]model.ServerEvent, 0, len(events))
+	for _, event := range events {
+		if role.CanSeeEvent(event, userId.(uuid.UUID), userRoles) {
+			visible = append(visible, event)
+		} else {
+			visible = append(visible, event.Redact())
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"server_events": visible})
+}
@@ -4,31 +4,164 @@ import (
 	"backend/logic"
 	"backend/model"
 	"context"
-	"time"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// MaxEventPage is the most events returned by a single replay query
+const MaxEventPage = 500
+
 type ServerEventStore struct {
+	DB             *pgxpool.Pool
 	ClientRegistry *logic.ClientRegistry
 }
 
-func NewServerEventStore(clientRegistry *logic.ClientRegistry) *ServerEventStore {
+func NewServerEventStore(db *pgxpool.Pool, clientRegistry *logic.ClientRegistry) *ServerEventStore {
 	return &ServerEventStore{
+		DB:             db,
 		ClientRegistry: clientRegistry,
 	}
 }
 
+// Create persists the event so it gets the next value of server_event_seq, then fans it out to connected clients.
 func (s ServerEventStore) Create(
 	ctx context.Context,
 	eventType model.ServerEventType,
 	payload any,
 	roles *[]string,
 ) (*model.ServerEvent, error) {
-	var serverEvent model.ServerEvent
-	serverEvent = model.ServerEvent{
+	return s.create(ctx, eventType, payload, roles, nil, nil)
+}
+
+// CreateExcluding is Create for an event that, when it has no roles, is still hidden from users with any of
+// deniedRoles, such as an event about a public room that some roles are denied.
+func (s ServerEventStore) CreateExcluding(
+	ctx context.Context,
+	eventType model.ServerEventType,
+	payload any,
+	roles *[]string,
+	deniedRoles []string,
+) (*model.ServerEvent, error) {
+	return s.create(ctx, eventType, payload, roles, &deniedRoles, nil)
+}
+
+// CreateForUsers is Create for an event addressed to exactly userIds, such as the participants of a DM. Everyone else
+// receives it redacted.
+func (s ServerEventStore) CreateForUsers(
+	ctx context.Context,
+	eventType model.ServerEventType,
+	payload any,
+	userIds []uuid.UUID,
+) (*model.ServerEvent, error) {
+	return s.create(ctx, eventType, payload, nil, nil, &userIds)
+}
+
+func (s ServerEventStore) create(
+	ctx context.Context,
+	eventType model.ServerEventType,
+	payload any,
+	roles *[]string,
+	deniedRoles *[]string,
+	userIds *[]uuid.UUID,
+) (*model.ServerEvent, error) {
+	asJson, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	serverEvent := model.ServerEvent{
 		ServerEventType: eventType,
 		Payload:         payload,
-		ServerEventTime: time.Now(),
+		Roles:           roles,
+		DeniedRoles:     deniedRoles,
+		UserIDs:         userIds,
 	}
+
+	err = s.DB.QueryRow(ctx,
+		`insert into open_discord.server_events (event_type, payload, roles, denied_roles, user_ids)
+		 values ($1, $2, $3, $4, $5)
+		 returning id, event_order, timestamp`,
+		string(eventType), string(asJson), roles, deniedRoles, userIds,
+	).Scan(&serverEvent.ServerEventID, &serverEvent.ServerEventOrder, &serverEvent.ServerEventTime)
+	if err != nil {
+		slog.Error("Failed to persist server event",
+			slog.String("eventType", string(eventType)),
+			slog.String("error", err.Error()),
+		)
+		return nil, err
+	}
+
 	s.ClientRegistry.FanOutMessage(serverEvent, roles)
 	return &serverEvent, nil
 }
+
+// GetEventsAfter returns up to limit events with an order strictly greater than afterOrder, oldest first.
+func (s ServerEventStore) GetEventsAfter(ctx context.Context, afterOrder int64, limit int) ([]model.ServerEvent, error) {
+	if limit <= 0 || limit > MaxEventPage {
+		limit = MaxEventPage
+	}
+	rows, err := s.DB.Query(ctx,
+		`select id, event_order, event_type, timestamp, payload, roles, denied_roles, user_ids
+		 from open_discord.server_events
+		 where event_order > $1
+		 order by event_order
+		 limit $2`,
+		afterOrder, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// GetEventsInRange returns events with start <= order <= end, oldest first. A nil end means no upper bound.
+func (s ServerEventStore) GetEventsInRange(ctx context.Context, start int64, end *int64) ([]model.ServerEvent, error) {
+	rows, err := s.DB.Query(ctx,
+		`select id, event_order, event_type, timestamp, payload, roles, denied_roles, user_ids
+		 from open_discord.server_events
+		 where event_order >= $1
+		 and ($2::bigint is null or event_order <= $2::bigint)
+		 order by event_order
+		 limit $3`,
+		start, end, MaxEventPage,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+func scanEvents(rows pgx.Rows) ([]model.ServerEvent, error) {
+	defer rows.Close()
+
+	var events []model.ServerEvent
+	for rows.Next() {
+		var event model.ServerEvent
+		var eventType string
+		var payload json.RawMessage
+		var roles []string
+		var deniedRoles []string
+		var userIds []uuid.UUID
+		err := rows.Scan(&event.ServerEventID, &event.ServerEventOrder, &eventType, &event.ServerEventTime, &payload, &roles, &deniedRoles, &userIds)
+		if err != nil {
+			return nil, err
+		}
+		event.ServerEventType = model.ServerEventType(eventType)
+		event.Payload = payload
+		if roles != nil {
+			event.Roles = &roles
+		}
+		if deniedRoles != nil {
+			event.DeniedRoles = &deniedRoles
+		}
+		if userIds != nil {
+			event.UserIDs = &userIds
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -6,34 +6,43 @@ import (
 	"backend/model"
 	"backend/role"
 	"backend/room"
+	"backend/serverevent"
 	"backend/user"
+	"context"
 	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 type SseHandler struct {
-	RoomService    *room.RoomService
-	Rooms          *map[uuid.UUID]*logic.Room
-	TokenService   *auth.TokenService
-	ClientRegistry *logic.ClientRegistry
-	UserService    *user.UserService
+	RoomService      *room.RoomService
+	Rooms            *logic.Rooms
+	TokenService     *auth.TokenService
+	ClientRegistry   *logic.ClientRegistry
+	UserService      *user.UserService
+	ServerEventStore *serverevent.ServerEventStore
 }
 
 func NewSseHandler(
 	roomService *room.RoomService,
-	Rooms *map[uuid.UUID]*logic.Room,
+	Rooms *logic.Rooms,
 	tokenService *auth.TokenService,
 	clientRegistry *logic.ClientRegistry,
 	userService *user.UserService,
+	serverEventStore *serverevent.ServerEventStore,
 ) *SseHandler {
 	return &SseHandler{
-		RoomService:    roomService,
-		Rooms:          Rooms,
-		TokenService:   tokenService,
-		ClientRegistry: clientRegistry,
-		UserService:    userService,
+		RoomService:      roomService,
+		Rooms:            Rooms,
+		TokenService:     tokenService,
+		ClientRegistry:   clientRegistry,
+		UserService:      userService,
+		ServerEventStore: serverEventStore,
 	}
 }
 
@@ -48,44 +57,169 @@ func (s *SseHandler) EstablishSSEConnection(c *gin.Context) {
 		return
 	}
 
-	sendChannel := make(chan model.ServerEvent, 50)
+	// Browsers' EventSource sends Last-Event-ID on reconnect. Clients that can't set headers may use the query param.
+	lastEventId := int64(0)
+	lastEventIdStr := c.GetHeader("Last-Event-ID")
+	if lastEventIdStr == "" {
+		lastEventIdStr = c.Query("last_event_id")
+	}
+	if lastEventIdStr != "" {
+		parsed, err := strconv.ParseInt(lastEventIdStr, 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid Last-Event-ID"})
+			return
+		}
+		lastEventId = parsed
+	}
 
-	roomClient := logic.RoomClient{
-		UserID:      userId.(uuid.UUID),
-		SendChannel: sendChannel,
+	// The registry filters live events by these roles, and UserService keeps them up to date when they change
+	userRoles, err := s.UserService.GetUserRoles(c.Request.Context(), userId.(uuid.UUID))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
 	}
 
-	s.ClientRegistry.Connect(&roomClient)
+	roomClient := logic.NewRoomClient(userId.(uuid.UUID), userRoles, logic.DefaultSendBufferSize)
+	if sessionId, exists := c.Get("session_id"); exists {
+		roomClient.SessionID = sessionId.(uuid.UUID)
+	}
+	sendChannel := roomClient.SendChannel
 
 	// Set CORS headers to allow all origins. You may want to restrict this to specific origins in a production environment.
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	c.Writer.Header().Set("Cache-Control", "no-cache")
 	c.Writer.Header().Set("Connection", "keep-alive")
 
+	// Replay before connecting, since a long replay would overflow the send buffer and get the client evicted. What
+	// is committed during it is caught up on once connected, which is short enough to fit in the buffer. Events
+	// commit out of order, so the catch-up starts from the same place rather than where the replay got to.
+	// sent is what either replay sent, which may arrive live as well.
+	sent := map[int64]bool{}
+	write := func(event model.ServerEvent) {
+		if role.CanSeeEvent(event, userId.(uuid.UUID), userRoles) {
+			writeEvent(c, event)
+		} else {
+			writeEvent(c, event.Redact())
+		}
+	}
+	replayedTo := lastEventId
+	if lastEventId > 0 {
+		replayedTo, err = replay(c.Request.Context(), s.ServerEventStore.GetEventsAfter, lastEventId, sent, write)
+		if err != nil {
+			slog.Error("Error replaying server events",
+				slog.String("username", username),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+	s.ClientRegistry.Connect(roomClient)
+	if lastEventId > 0 {
+		caughtUpTo, err := replay(c.Request.Context(), s.ServerEventStore.GetEventsAfter, lastEventId, sent, write)
+		lastEventId = max(replayedTo, caughtUpTo)
+		if err != nil {
+			slog.Error("Error catching up on server events",
+				slog.String("username", username),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
 	slog.Info("Established connection with " + username + ", waiting on messages to send them.")
 
-	// If the client gets a message with a lastMessage ID they have not actually received, they know they missed something
-	// and can re-sync with the backend
-	clientMessageId := 0
 	for {
 		select {
 		case <-c.Request.Context().Done():
-			slog.Info("Closed client connection to ", username)
+			slog.Info("Closed client connection", slog.String("username", username))
+			s.ClientRegistry.Disconnect(roomClient)
+			return
+
+		case <-roomClient.Closed():
+			slog.Info("Closing connection whose session was revoked or user disabled", slog.String("username", username))
+			s.ClientRegistry.Disconnect(roomClient)
+			return
+
+		case <-roomClient.Evicted():
+			// We fell too far behind and events were dropped, so have the client reconnect and replay from its
+			// Last-Event-ID rather than silently skipping them
+			slog.Warn("Closing evicted client connection", slog.String("username", username))
+			writeEvent(c, model.ServerEvent{
+				ServerEventType: model.ResyncRequired,
+				ServerEventTime: time.Now(),
+				Payload:         gin.H{"last_event_id": lastEventId},
+			})
 			s.ClientRegistry.Disconnect(roomClient)
 			return
 
 		case message := <-sendChannel:
-			userRoles, err := s.UserService.GetUserRoles(c.Request.Context(), userId.(uuid.UUID))
-			if err != nil {
-				slog.Error("Error fetching user roles for SSE connection: ", err)
+			// Already filtered by the registry: events this user can't see arrive redacted or not at all. Events
+			// commit and are fanned out out of order, so only those the replays sent are skipped, never ones below
+			// the latest sent.
+			if alreadySent(sent, message.ServerEventOrder) {
 				continue
 			}
-			if role.HasCommonRole(&userRoles, message.Roles) {
-				message.ClientMessageId = clientMessageId + 1
-				clientMessageId++
-				c.SSEvent(string(message.ServerEventType), message)
-				c.Writer.Flush()
+			writeEvent(c, message)
+			if message.ServerEventOrder != 0 {
+				lastEventId = message.ServerEventOrder
 			}
 		}
 	}
 }
+
+// eventsAfter reads a page of persisted events, as ServerEventStore.GetEventsAfter does
+type eventsAfter func(ctx context.Context, afterOrder int64, limit int) ([]model.ServerEvent, error)
+
+// replay writes every persisted event after lastEventId that isn't in sent, adds them to sent, and returns the order
+// of the last event read from the store
+func replay(
+	ctx context.Context,
+	getEventsAfter eventsAfter,
+	lastEventId int64,
+	sent map[int64]bool,
+	write func(model.ServerEvent),
+) (int64, error) {
+	for {
+		events, err := getEventsAfter(ctx, lastEventId, serverevent.MaxEventPage)
+		if err != nil {
+			return lastEventId, err
+		}
+		for _, event := range events {
+			if !sent[event.ServerEventOrder] {
+				write(event)
+				sent[event.ServerEventOrder] = true
+			}
+			lastEventId = event.ServerEventOrder
+		}
+		if len(events) < serverevent.MaxEventPage {
+			return lastEventId, nil
+		}
+	}
+}
+
+// alreadySent reports whether a live event was sent by a replay. Each event arrives live at most once, so it is then
+// forgotten.
+func alreadySent(sent map[int64]bool, order int64) bool {
+	if order == 0 || !sent[order] {
+		return false
+	}
+	delete(sent, order)
+	return true
+}
+
+// writeEvent renders a single event, using its order as the SSE id so the client can resume from it
+func writeEvent(c *gin.Context, event model.ServerEvent) {
+	sseEvent := sse.Event{
+		Event: string(event.ServerEventType),
+		Data:  event,
+	}
+	if event.ServerEventOrder != 0 {
+		sseEvent.Id = strconv.FormatInt(event.ServerEventOrder, 10)
+	}
+	c.Render(-1, sseEvent)
+	c.Writer.Flush()
+}
+
+// HandleGetConnectionStats reports the fan-out queues of this instance's SSE connections. Bind it behind
+// role.RequirePermission(role.ViewStats).
+func (s *SseHandler) HandleGetConnectionStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.ClientRegistry.Stats())
+}
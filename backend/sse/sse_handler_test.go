@@ -0,0 +1,64 @@
+package sse
+
+import (
+	"backend/model"
+	"context"
+	"slices"
+	"testing"
+)
+
+// fakeEventStore holds the events that have committed so far, which needn't be in order
+type fakeEventStore struct {
+	committed []int64
+}
+
+func (s *fakeEventStore) commit(order int64) {
+	s.committed = append(s.committed, order)
+}
+
+func (s *fakeEventStore) getEventsAfter(ctx context.Context, afterOrder int64, limit int) ([]model.ServerEvent, error) {
+	orders := slices.Clone(s.committed)
+	slices.Sort(orders)
+	events := []model.ServerEvent{}
+	for _, order := range orders {
+		if order > afterOrder && len(events) < limit {
+			events = append(events, model.ServerEvent{ServerEventOrder: order})
+		}
+	}
+	return events, nil
+}
+
+func TestReplaySendsEventsCommittedOutOfOrder(t *testing.T) {
+	store := &fakeEventStore{}
+	for _, order := range []int64{1, 2, 4, 6} {
+		store.commit(order)
+	}
+
+	var written []int64
+	write := func(event model.ServerEvent) { written = append(written, event.ServerEventOrder) }
+	sent := map[int64]bool{}
+	ctx := context.Background()
+
+	// The client last saw 1, and 3 and 5 were given their orders but haven't committed
+	if _, err := replay(ctx, store.getEventsAfter, 1, sent, write); err != nil {
+		t.Fatal(err)
+	}
+	store.commit(3)
+	if _, err := replay(ctx, store.getEventsAfter, 1, sent, write); err != nil {
+		t.Fatal(err)
+	}
+	store.commit(5)
+	store.commit(7)
+
+	// 3, 4 and 6 were published while the replays ran, so they arrive live too
+	for _, order := range []int64{4, 3, 6, 5, 7} {
+		if !alreadySent(sent, order) {
+			write(model.ServerEvent{ServerEventOrder: order})
+		}
+	}
+
+	want := []int64{2, 4, 6, 3, 5, 7}
+	if !slices.Equal(written, want) {
+		t.Errorf("wrote %v, want %v", written, want)
+	}
+}
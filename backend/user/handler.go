@@ -1,6 +1,8 @@
 package user
 
 import (
+	"backend/role"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -9,11 +11,22 @@ import (
 
 type UserHandler struct {
 	UserService *UserService
+	RoleService *role.Service
+}
+
+func NewUserHandler(userService *UserService, roleService *role.Service) *UserHandler {
+	return &UserHandler{
+		UserService: userService,
+		RoleService: roleService,
+	}
 }
 
 func BindUserRoutes(router *gin.Engine, handler *UserHandler) {
+	requireManageRoles := role.RequirePermission(handler.RoleService, role.ManageRoles)
 	router.GET("/users", handler.GetAllUsers)
 	router.GET("/users/:id", handler.GetUserByID)
+	router.PUT("/users/:id/roles/:roleName", requireManageRoles, handler.HandleAssignRole)
+	router.DELETE("/users/:id/roles/:roleName", requireManageRoles, handler.HandleRemoveRole)
 }
 
 func (h *UserHandler) GetAllUsers(c *gin.Context) {
@@ -40,3 +53,80 @@ func (h *UserHandler) GetUserByID(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, result)
 }
+
+// HandleAssignRole gives a user a role. The role must be below the caller's highest role and grant nothing the caller
+// doesn't have, and the user must be the caller or ranked below them.
+func (h *UserHandler) HandleAssignRole(c *gin.Context) {
+	h.handleRoleChange(c, true)
+}
+
+// HandleRemoveRole takes a role away from a user, with the same checks as HandleAssignRole except that the role may
+// grant permissions the caller doesn't have
+func (h *UserHandler) HandleRemoveRole(c *gin.Context) {
+	h.handleRoleChange(c, false)
+}
+
+func (h *UserHandler) handleRoleChange(c *gin.Context, assign bool) {
+	targetId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+	userId, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	actor, err := role.GetActor(c, h.RoleService)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	targetRole, err := h.RoleService.GetRole(c, c.Param("roleName"))
+	if errors.Is(err, role.ErrRoleNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	targetPosition, err := h.RoleService.PositionOf(c, targetId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = actor.CanManageUser(targetPosition, targetId == userId.(uuid.UUID))
+	if err == nil && assign {
+		err = actor.CanGrant(*targetRole)
+	} else if err == nil {
+		err = actor.CanManage(*targetRole)
+	}
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if assign {
+		err = h.UserService.AssignRole(c, targetId, targetRole.ID)
+	} else {
+		err = h.UserService.RemoveRole(c, targetId, targetRole.ID)
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	roles, err := h.UserService.GetUserRoles(c, targetId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
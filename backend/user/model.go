@@ -8,4 +8,5 @@ type User struct {
 	Username string    `json:"username"`
 	IsOnline bool      `json:"is_online"`
 	Roles    []string  `json:"roles"`
+	Disabled bool      `json:"disabled"`
 }
@@ -2,16 +2,22 @@ package user
 
 import (
 	"backend/logic"
+	"backend/role"
 	"context"
+	"errors"
 	"log/slog"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+
 type UserService struct {
 	DB             *pgxpool.Pool
 	ClientRegistry *logic.ClientRegistry
@@ -65,7 +71,7 @@ func (u UserService) GetUserByUsername(ctx context.Context, username string) (*U
 
 func (u UserService) GetAllUsers(ctx context.Context) ([]User, error) {
 	var users []User
-	rows, err := u.DB.Query(ctx, "select id, nickname, username from open_discord.users")
+	rows, err := u.DB.Query(ctx, "select id, nickname, username, disabled_at is not null from open_discord.users order by username")
 
 	if err != nil {
 		return nil, err
@@ -74,7 +80,7 @@ func (u UserService) GetAllUsers(ctx context.Context) ([]User, error) {
 	defer rows.Close()
 	for rows.Next() {
 		var user User
-		err = rows.Scan(&user.UserID, &user.Nickname, &user.Username)
+		err = rows.Scan(&user.UserID, &user.Nickname, &user.Username, &user.Disabled)
 		if err != nil {
 			return nil, err
 		}
@@ -160,25 +166,42 @@ func (u UserService) AssignUserToRole(ctx context.Context, username string, role
 	var roleId uuid.UUID
 
 	err := u.DB.QueryRow(ctx, "select id from open_discord.users where username = $1", username).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
 	if err != nil {
 		return err
 	}
 
 	err = u.DB.QueryRow(ctx, "select id from open_discord.roles where name = $1", rolename).Scan(&roleId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return role.ErrRoleNotFound
+	}
 	if err != nil {
 		return err
 	}
 
-	_, err = u.DB.Exec(ctx, "insert into open_discord.user_roles(user_id, role_id) values ($1, $2)", userId, roleId)
+	return u.AssignRole(ctx, userId, roleId)
+}
 
-	// Invalidate the cache for the user's roles since we've made a change
-	slog.Info("Invalidating role cache for user", slog.String("username", username))
-	redisKey := userRoleRedisKey(userId)
-	err = u.RedisClient.Del(ctx, redisKey).Err()
+// AssignRole gives the user the role. Assigning a role the user already has does nothing.
+func (u UserService) AssignRole(ctx context.Context, userId, roleId uuid.UUID) error {
+	_, err := u.DB.Exec(ctx,
+		"insert into open_discord.user_roles(user_id, role_id) values ($1, $2) on conflict do nothing",
+		userId, roleId,
+	)
+	var pgErr *pgconn.PgError
+	// 23503 is foreign_key_violation
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return ErrUserNotFound
+	}
 	if err != nil {
-		slog.Error("Error invalidating user roles cache", slog.String("user_id", userId.String()))
+		return err
 	}
-	return err
+
+	slog.Info("Invalidating role cache for user", slog.String("user_id", userId.String()))
+	u.refreshRoles(ctx, userId)
+	return nil
 }
 
 func (u UserService) RemoveUserFromRole(ctx context.Context, username string, rolename string) error {
@@ -186,15 +209,118 @@ func (u UserService) RemoveUserFromRole(ctx context.Context, username string, ro
 	var roleId uuid.UUID
 
 	err := u.DB.QueryRow(ctx, "select id from open_discord.users where username = $1", username).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
 	if err != nil {
 		return err
 	}
 
 	err = u.DB.QueryRow(ctx, "select id from open_discord.roles where name = $1", rolename).Scan(&roleId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return role.ErrRoleNotFound
+	}
 	if err != nil {
 		return err
 	}
 
-	_, err = u.DB.Exec(ctx, "delete from open_discord.user_roles where user_id = $1 and role_id = $2", userId, roleId)
-	return err
+	return u.RemoveRole(ctx, userId, roleId)
+}
+
+// RemoveRole takes the role away from the user
+func (u UserService) RemoveRole(ctx context.Context, userId, roleId uuid.UUID) error {
+	_, err := u.DB.Exec(ctx, "delete from open_discord.user_roles where user_id = $1 and role_id = $2", userId, roleId)
+	if err != nil {
+		return err
+	}
+
+	u.refreshRoles(ctx, userId)
+	return nil
+}
+
+// userDisabledCacheTTL is how long whether a user is disabled is cached for
+const userDisabledCacheTTL = 5 * time.Minute
+
+func userDisabledRedisKey(userId uuid.UUID) string {
+	return "user_disabled:" + userId.String()
+}
+
+// IsDisabled reports whether the user's account is disabled. It is checked on every request, so it is cached.
+func (u UserService) IsDisabled(ctx context.Context, userId uuid.UUID) (bool, error) {
+	redisKey := userDisabledRedisKey(userId)
+	cached, err := u.RedisClient.Get(ctx, redisKey).Result()
+	if err == nil {
+		return cached == "1", nil
+	}
+
+	var disabled bool
+	err = u.DB.QueryRow(ctx, "select disabled_at is not null from open_discord.users where id = $1", userId).Scan(&disabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrUserNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	value := "0"
+	if disabled {
+		value = "1"
+	}
+	// Only fill in a missing value, so this can't overwrite what SetDisabled cached after the read above
+	err = u.RedisClient.SetNX(ctx, redisKey, value, userDisabledCacheTTL).Err()
+	if err != nil {
+		slog.Error("Error caching whether user is disabled", slog.String("user_id", userId.String()), slog.String("error", err.Error()))
+	}
+	return disabled, nil
+}
+
+// SetDisabled disables or re-enables the user's account. Disabling also closes the user's open connections.
+func (u UserService) SetDisabled(ctx context.Context, username string, disabled bool) error {
+	var userId uuid.UUID
+	err := u.DB.QueryRow(ctx,
+		`update open_discord.users
+		set disabled_at = case when $2 then coalesce(disabled_at, now()) end
+		where username = $1
+		returning id`,
+		username, disabled,
+	).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Overwrite rather than delete the cached state, so a check racing with this can't cache the old state
+	value := "0"
+	if disabled {
+		value = "1"
+	}
+	err = u.RedisClient.Set(ctx, userDisabledRedisKey(userId), value, userDisabledCacheTTL).Err()
+	if err != nil {
+		slog.Error("Error caching whether user is disabled", slog.String("user_id", userId.String()))
+	}
+	if disabled {
+		u.ClientRegistry.DisconnectUser(userId)
+	}
+	return nil
+}
+
+// refreshRoles invalidates the cache for the user's roles since we've made a change, and hands the new roles to their
+// open connections so the events they receive follow the change straight away
+func (u UserService) refreshRoles(ctx context.Context, userId uuid.UUID) {
+	err := u.RedisClient.Del(ctx, userRoleRedisKey(userId)).Err()
+	if err != nil {
+		slog.Error("Error invalidating user roles cache", slog.String("user_id", userId.String()))
+	}
+
+	roles, err := u.GetUserRoles(ctx, userId)
+	if err != nil {
+		slog.Error("Error refreshing roles of connected user",
+			slog.String("user_id", userId.String()),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	u.ClientRegistry.UpdateRoles(userId, roles)
 }
@@ -1,76 +1,111 @@
 package util
 
 import (
+	"backend/admin"
+	"backend/attachment"
 	auth "backend/auth"
 	"backend/logic"
 	"backend/message"
+	"backend/role"
 	"backend/serverevent"
 	"backend/sse"
 
 	"backend/room"
 	"backend/user"
 
-	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
 type Services struct {
-	UsersService     user.UserService
-	RoomsService     room.RoomService
-	AuthService      auth.Service
-	TokenService     auth.TokenService
-	ServerEventStore serverevent.ServerEventStore
-	MessageService   message.Service
+	UsersService      user.UserService
+	RoomsService      room.RoomService
+	RoleService       role.Service
+	AuthService       auth.Service
+	Otc               auth.Otc
+	TokenService      auth.TokenService
+	SessionService    auth.SessionService
+	TwoFactorService  auth.TwoFactorService
+	ServerEventStore  serverevent.ServerEventStore
+	MessageService    message.Service
+	AttachmentService attachment.Service
 }
 
 func CreateServices(
 	db *pgxpool.Pool,
 	secret string,
-	rooms *map[uuid.UUID]*logic.Room,
+	rooms *logic.Rooms,
 	clientRegistry *logic.ClientRegistry,
 	redisClient *redis.Client,
+	attachmentConfig attachment.Config,
+	blobStore attachment.BlobStore,
 ) *Services {
 	usersService := user.NewUserService(db, clientRegistry, redisClient)
+	sessionService := auth.NewSessionService(db, redisClient, clientRegistry)
 	return &Services{
-		UsersService:     *usersService,
-		RoomsService:     *room.NewRoomService(db, redisClient),
-		AuthService:      auth.Service{DB: db},
-		TokenService:     auth.TokenService{Secret: []byte(secret), UserService: usersService},
-		ServerEventStore: serverevent.ServerEventStore{ClientRegistry: clientRegistry},
-		MessageService:   *message.NewMessageService(db),
+		UsersService:      *usersService,
+		RoomsService:      *room.NewRoomService(db, redisClient),
+		RoleService:       role.Service{DB: db},
+		AuthService:       auth.Service{DB: db},
+		Otc:               auth.Otc{DB: db},
+		TokenService:      auth.TokenService{Secret: []byte(secret), UserService: usersService, Sessions: sessionService},
+		SessionService:    *sessionService,
+		TwoFactorService:  *auth.NewTwoFactorService(db, redisClient),
+		ServerEventStore:  *serverevent.NewServerEventStore(db, clientRegistry),
+		MessageService:    *message.NewMessageService(db),
+		AttachmentService: *attachment.NewAttachmentService(db, blobStore, attachmentConfig),
 	}
 }
 
 type Handlers struct {
-	AuthHandler     auth.AuthHandler
-	UserHandler     user.UserHandler
-	RoomHandler     room.RoomHandler
-	MessagesHandler message.MessageHandler
-	SseHandler      sse.SseHandler
+	AdminHandler       admin.AdminHandler
+	AuthHandler        auth.AuthHandler
+	UserHandler        user.UserHandler
+	RoleHandler        role.RoleHandler
+	RoomHandler        room.RoomHandler
+	MessagesHandler    message.MessageHandler
+	SseHandler         sse.SseHandler
+	EventsHandler      serverevent.ServerEventHandler
+	AttachmentsHandler attachment.AttachmentHandler
 }
 
-func CreateHandlers(services *Services, rooms *map[uuid.UUID]*logic.Room, clientRegistry *logic.ClientRegistry) *Handlers {
+func CreateHandlers(services *Services, rooms *logic.Rooms, clientRegistry *logic.ClientRegistry) *Handlers {
 	return &Handlers{
+		AdminHandler: *admin.NewAdminHandler(
+			&services.Otc,
+			&services.RoleService,
+			&services.UsersService,
+			&services.RoomsService,
+		),
 		AuthHandler: *auth.NewAuthHandler(
 			&services.AuthService,
 			&services.TokenService,
 			&services.UsersService,
+			&services.Otc,
+			&services.RoleService,
+			&services.SessionService,
+			&services.TwoFactorService,
 		),
-		UserHandler: user.UserHandler{
-			UserService: &services.UsersService,
-		},
+		UserHandler: *user.NewUserHandler(
+			&services.UsersService,
+			&services.RoleService,
+		),
+		RoleHandler: *role.NewRoleHandler(&services.RoleService),
 		RoomHandler: *room.NewRoomHandler(
 			&services.RoomsService,
+			&services.RoleService,
 			rooms,
 			clientRegistry,
 			&services.ServerEventStore,
+			&services.AttachmentService,
 		),
 		MessagesHandler: *message.NewMessageHandler(
 			&services.ServerEventStore,
 			&services.UsersService,
 			&services.RoomsService,
+			&services.RoleService,
 			&services.MessageService,
+			&services.AttachmentService,
 		),
 		SseHandler: *sse.NewSseHandler(
 			&services.RoomsService,
@@ -78,6 +113,16 @@ func CreateHandlers(services *Services, rooms *map[uuid.UUID]*logic.Room, client
 			&services.TokenService,
 			clientRegistry,
 			&services.UsersService,
+			&services.ServerEventStore,
+		),
+		EventsHandler: *serverevent.NewServerEventHandler(
+			&services.ServerEventStore,
+			&services.UsersService,
+		),
+		AttachmentsHandler: *attachment.NewAttachmentHandler(
+			&services.AttachmentService,
+			&services.UsersService,
+			&services.RoomsService,
 		),
 	}
 }
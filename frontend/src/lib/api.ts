@@ -1,6 +1,6 @@
 import { get } from 'svelte/store';
-import { authToken } from './stores';
-import type { ApiResult, SigninResponse, SignupResponse, MessagesResponse, MessageCreateResponse, Room, ServerEventsResponse, CheckPasswordResponse, ChangePasswordResponse, User } from './types';
+import { authToken, currentUser, refreshToken } from './stores';
+import type { ApiResult, SigninResponse, TwoFactorChallengeResponse, TwoFactorStatus, TotpEnrollment, RecoveryCodesResponse, SessionsResponse, SignupResponse, MessagesResponse, MessageCreateResponse, Room, ServerEventsResponse, CheckPasswordResponse, ChangePasswordResponse, User } from './types';
 
 const BASE = import.meta.env.VITE_API_BASE || '/api';
 
@@ -16,7 +16,7 @@ const BASE = import.meta.env.VITE_API_BASE || '/api';
  * `{ "error": "..." }` which we rewrite to `{ _error: "..." }` so
  * callers can narrow with `'_error' in result`.
  */
-async function request<T>(path: string, options: RequestInit = {}): Promise<ApiResult<T>> {
+async function request<T>(path: string, options: RequestInit = {}, retry = true): Promise<ApiResult<T>> {
   try {
     const token = get(authToken);
     // RequestInit.headers is a wide union type (HeadersInit); we only
@@ -29,6 +29,13 @@ async function request<T>(path: string, options: RequestInit = {}): Promise<ApiR
       headers,
       ...options,
     });
+    // Access tokens are short-lived, so get a new one and try once more. Without one the session is over.
+    if (res.status === 401 && retry && token && path !== '/signin') {
+      if (await refreshSession()) {
+        return request<T>(path, options, false);
+      }
+      endSession();
+    }
     if (!res.ok) {
       try {
         const body = await res.json();
@@ -43,6 +50,66 @@ async function request<T>(path: string, options: RequestInit = {}): Promise<ApiR
   }
 }
 
+/** Keeps the tokens from a sign in or refresh, in the stores and in localStorage for other tabs and reloads. */
+export function storeTokens(response: SigninResponse): void {
+  localStorage.setItem('token', response.data);
+  localStorage.setItem('refresh_token', response.refresh_token);
+  authToken.set(response.data);
+  refreshToken.set(response.refresh_token);
+}
+
+/** Forgets the tokens and the user, which shows the sign in page, for when the session was revoked or has expired. */
+export function endSession(): void {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
+  authToken.set(null);
+  refreshToken.set(null);
+  currentUser.set(null);
+}
+
+let refreshing: Promise<string | null> | null = null;
+
+/**
+ * Swaps the refresh token for a new access token, returning it, or null if the session is over. Concurrent callers
+ * share one refresh, since each refresh token only works once.
+ */
+export function refreshSession(): Promise<string | null> {
+  if (!refreshing) {
+    refreshing = doRefresh().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
+async function doRefresh(): Promise<string | null> {
+  const sent = localStorage.getItem('refresh_token');
+  if (!sent) return null;
+  try {
+    const res = await fetch(`${BASE}/refresh`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ refresh_token: sent }),
+    });
+    if (res.ok) {
+      const body = await res.json() as SigninResponse;
+      storeTokens(body);
+      return body.data;
+    }
+  } catch {
+    return null;
+  }
+  // Another tab may have refreshed with the same token first, in which case use what it stored
+  const stored = localStorage.getItem('refresh_token');
+  if (stored && stored !== sent) {
+    const token = localStorage.getItem('token');
+    authToken.set(token);
+    refreshToken.set(stored);
+    return token;
+  }
+  return null;
+}
+
 export function signup(username: string, password: string, otc: string): Promise<ApiResult<SignupResponse>> {
   return request<SignupResponse>('/signup', {
     method: 'POST',
@@ -50,13 +117,66 @@ export function signup(username: string, password: string, otc: string): Promise
   });
 }
 
-export function signin(username: string, password: string): Promise<ApiResult<SigninResponse>> {
-  return request<SigninResponse>('/signin', {
+/** POST /signin — users with two-factor authentication get a challenge to pass to signinTwoFactor instead of tokens. */
+export function signin(username: string, password: string): Promise<ApiResult<SigninResponse | TwoFactorChallengeResponse>> {
+  return request<SigninResponse | TwoFactorChallengeResponse>('/signin', {
     method: 'POST',
     body: JSON.stringify({ username, password }),
   });
 }
 
+/** POST /signin/2fa — finishes signing in with a code from the user's app or a recovery code. */
+export function signinTwoFactor(challenge: string, code: string): Promise<ApiResult<SigninResponse>> {
+  return request<SigninResponse>('/signin/2fa', {
+    method: 'POST',
+    body: JSON.stringify({ challenge, code }),
+  });
+}
+
+/** GET /2fa — whether the current user has two-factor authentication. */
+export function getTwoFactor(): Promise<ApiResult<TwoFactorStatus>> {
+  return request<TwoFactorStatus>('/2fa');
+}
+
+/** POST /2fa/enroll — a new secret for an authenticator app. Nothing changes until confirmTwoFactor. */
+export function enrollTwoFactor(): Promise<ApiResult<TotpEnrollment>> {
+  return request<TotpEnrollment>('/2fa/enroll', { method: 'POST' });
+}
+
+/** POST /2fa/confirm — turns two-factor authentication on with a code from the app. */
+export function confirmTwoFactor(code: string): Promise<ApiResult<RecoveryCodesResponse>> {
+  return request<RecoveryCodesResponse>('/2fa/confirm', {
+    method: 'POST',
+    body: JSON.stringify({ code }),
+  });
+}
+
+/** POST /2fa/recovery_codes — replaces the recovery codes, which takes a current code. */
+export function regenerateRecoveryCodes(code: string): Promise<ApiResult<RecoveryCodesResponse>> {
+  return request<RecoveryCodesResponse>('/2fa/recovery_codes', {
+    method: 'POST',
+    body: JSON.stringify({ code }),
+  });
+}
+
+/** POST /2fa/disable — turns two-factor authentication off, which takes a current code. */
+export function disableTwoFactor(code: string): Promise<ApiResult<{ data: string }>> {
+  return request<{ data: string }>('/2fa/disable', {
+    method: 'POST',
+    body: JSON.stringify({ code }),
+  });
+}
+
+/** GET /sessions — the current user's signed in devices. */
+export function getSessions(): Promise<ApiResult<SessionsResponse>> {
+  return request<SessionsResponse>('/sessions');
+}
+
+/** DELETE /sessions/:id — signs a device out, closing its live connection. */
+export function revokeSession(id: string): Promise<ApiResult<null>> {
+  return request<null>(`/sessions/${id}`, { method: 'DELETE' });
+}
+
 /** POST /rooms — Go returns the Room struct directly, not wrapped in gin.H. */
 export function createRoom(name: string): Promise<ApiResult<Room>> {
   return request<Room>('/rooms', {
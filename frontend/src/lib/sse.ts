@@ -1,5 +1,6 @@
-import { messagesByRoom, rooms } from './stores';
-import { getRooms } from './api';
+import { get } from 'svelte/store';
+import { authToken, messagesByRoom, rooms } from './stores';
+import { getRooms, refreshSession } from './api';
 import { ensureUser } from './users';
 import type { Message, Room, ServerEvent } from './types';
 
@@ -10,6 +11,9 @@ const seenIds = new Set<string>();
 // ReturnType<typeof setTimeout> avoids Node vs browser timer ID mismatch.
 let reconnectTimeout: ReturnType<typeof setTimeout> | null = null;
 let reconnectDelay = 1000;
+// Order of the last persisted event we processed, sent back as Last-Event-ID
+// on reconnect so the backend replays whatever we missed.
+let lastEventId: string | null = null;
 
 // --- SSE event handlers ---
 
@@ -96,15 +100,19 @@ function processChunk(buffer: string): string {
     const lines = event.split('\n');
     let data = '';
     let eventType = '';
+    let id = '';
     for (const line of lines) {
       if (line.startsWith('data:')) {
         data += line.slice(line.charAt(5) === ' ' ? 6 : 5);
       } else if (line.startsWith('event:')) {
         eventType = line.slice(line.charAt(6) === ' ' ? 7 : 6);
+      } else if (line.startsWith('id:')) {
+        id = line.slice(line.charAt(3) === ' ' ? 4 : 3);
       }
     }
     if (!data || !eventType) continue;
     handleEvent(eventType, data);
+    if (id) lastEventId = id;
   }
 
   return remainder;
@@ -149,11 +157,22 @@ function startConnection(token: string, username: string): void {
   abortController = new AbortController();
 
   const sseBase = import.meta.env.VITE_SSE_BASE || '/sse';
+  const headers: Record<string, string> = { Authorization: `Bearer ${token}` };
+  if (lastEventId) headers['Last-Event-ID'] = lastEventId;
   fetch(`${sseBase}/connect`, {
-    headers: { Authorization: `Bearer ${token}` },
+    headers,
     signal: abortController.signal,
   })
-    .then((response) => {
+    .then(async (response) => {
+      // The access token expired, so reconnect with a new one, or the session was revoked, so stop
+      if (response.status === 401) {
+        if (await refreshSession()) {
+          reconnectDelay = 1000;
+        } else {
+          disconnectSSE();
+        }
+        return;
+      }
       if (!response.ok) throw new Error(`SSE connect failed: ${response.status}`);
       reconnectDelay = 1000;
       // response.body is non-null for successful fetch responses.
@@ -171,6 +190,8 @@ function startConnection(token: string, username: string): void {
 function scheduleReconnect(): void {
   if (!_currentToken || !_currentUsername) return;
   reconnectTimeout = setTimeout(() => {
+    // The access token may have been refreshed since the connection was opened
+    _currentToken = get(authToken) ?? _currentToken;
     // Non-null assertions are safe: the guard above ensures both are
     // set when we schedule, and disconnectSSE() clears the timeout
     // synchronously before nulling them.
@@ -191,4 +212,5 @@ export function disconnectSSE(): void {
     abortController = null;
   }
   seenIds.clear();
+  lastEventId = null;
 }
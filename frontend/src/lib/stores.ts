@@ -2,6 +2,8 @@ import { writable, type Writable } from 'svelte/store';
 import type { Room, MessagesByRoom } from './types';
 
 export const authToken: Writable<string | null> = writable(localStorage.getItem('token'));
+// Swapped for a new access token, and a new refresh token, when the access token expires. See refreshSession in api.ts.
+export const refreshToken: Writable<string | null> = writable(localStorage.getItem('refresh_token'));
 
 // Only holds { username } because auth extracts just the username from
 // the JWT — the backend has no "get current user" endpoint that returns
@@ -18,6 +18,75 @@ export interface Room {
   name: string;
   sort_order: number;
   starred: boolean;
+  /** 'dm' rooms have a generated name; show their participants instead */
+  kind: 'room' | 'dm';
+  /** Only set for DMs */
+  participants?: string[];
+}
+
+/** Go: role.Permission names */
+export type Permission =
+  | 'create_room'
+  | 'delete_room'
+  | 'manage_rooms'
+  | 'manage_roles'
+  | 'delete_any_message'
+  | 'mint_otc'
+  | 'mention_everyone'
+  | 'view_stats'
+  | 'administer';
+
+/**
+ * Go: role.Role — GET /roles returns { roles: Role[] }, highest position first. POST /roles and PATCH
+ * /roles/:roleName take permissions and position; PUT and DELETE /users/:id/roles/:roleName assign and remove.
+ */
+export interface Role {
+  id: string;
+  name: string;
+  permissions: Permission[];
+  position: number;
+}
+
+/** Go: role.RoomPermission names */
+export type RoomPermission = 'view' | 'post' | 'react' | 'attach';
+
+/** Go: room.RoomRole — GET /rooms/:roomId/roles returns { roles: RoomRole[] }. PUT takes { allow, deny }. */
+export interface RoomRole {
+  name: string;
+  allow: RoomPermission[];
+  deny: RoomPermission[];
+}
+
+/**
+ * Go: auth.SignupOtc — an invite. POST /otcs takes an optional CreateOtcRequest and returns { otc: code, invite }.
+ * The /admin/otcs endpoints list, show and revoke them; only GET /admin/otcs/:code fills in redemptions.
+ */
+export interface SignupOtc {
+  code: string;
+  label?: string;
+  max_uses: number;
+  uses: number;
+  /** Granted on signup in addition to default */
+  roles: string[];
+  created_by: string | null;
+  time_created: string;
+  /** null never expires */
+  time_expires: string | null;
+  revoked_at: string | null;
+  redemptions?: { user_id: string; username: string; time_redeemed: string }[];
+}
+
+/** Go: auth.CreateOtcRequest. expires_in is in seconds, defaulting to an hour; 0 never expires. */
+export interface CreateOtcRequest {
+  label?: string;
+  max_uses?: number;
+  expires_in?: number;
+  roles?: string[];
+}
+
+/** Go: room.CreateDMRequest — body of POST /dms. The caller is added automatically. */
+export interface CreateDMRequest {
+  user_ids: string[];
 }
 
 /** Go: domain.Message (message.go) */
@@ -27,6 +96,37 @@ export interface Message {
   message: string;
   user_id: string;
   timestamp: string;
+  edited_at: string | null;
+  deleted_at: string | null;
+  parent_id: string | null;
+  reply_count: number;
+  last_reply_at: string | null;
+  reactions: Reaction[] | null;
+  attachments: Attachment[] | null;
+}
+
+/** Go: model.Attachment (attachment.go). Download from GET /attachments/:id. */
+export interface Attachment {
+  id: string;
+  room_id: string;
+  uploader_id: string;
+  message_id: string | null;
+  filename: string;
+  content_type: string;
+  size: number;
+  created_at: string;
+  /** Set once an image is processed. Its thumbnail is GET /attachments/:id/thumbnail. */
+  width: number | null;
+  height: number | null;
+  blurhash: string | null;
+  has_thumbnail: boolean;
+}
+
+/** Go: model.Reaction (server_events.go). `reacted` is the current user's. */
+export interface Reaction {
+  emoji: string;
+  count: number;
+  reacted: boolean;
 }
 
 /**
@@ -42,6 +142,8 @@ export interface User {
   username: string;
   is_online: boolean;
   roles: string[];
+  /** Disabled users can't sign in, and their tokens are rejected with 401 */
+  disabled: boolean;
 }
 
 /**
@@ -54,6 +156,8 @@ export interface User {
 export interface JWTClaims {
   id: string;
   username: string;
+  /** The session the token belongs to. DELETE /sessions/:sid signs out. */
+  sid: string;
   exp: number;
   iat: number;
   nbf?: number;
@@ -103,7 +207,15 @@ export type ServerEventType =
   | 'user_joined'
   | 'user_left'
   | 'room_created'
-  | 'room_deleted';
+  | 'room_deleted'
+  | 'room_renamed'
+  | 'message_edited'
+  | 'message_deleted'
+  | 'thread_reply'
+  | 'reaction_added'
+  | 'reaction_removed'
+  | 'redacted'
+  | 'resync_required';
 
 /**
  * Go: ServerEvent (message.go)
@@ -118,6 +230,16 @@ export interface ServerEvent {
   server_event_order: number;
   server_event_time: string;
   payload: unknown;
+  /** Set on events addressed to specific users, such as the participants of a DM */
+  user_ids?: string[];
+}
+
+/** Go: model.ReactionEvent — payload of reaction_added/reaction_removed */
+export interface ReactionEvent {
+  message_id: string;
+  room_id: string;
+  user_id: string;
+  emoji: string;
 }
 
 // ---------------------------------------------------------------------
@@ -164,9 +286,62 @@ export interface ServerEventsResponse {
 // createRoom → Room) don't need a wrapper type.
 // ---------------------------------------------------------------------
 
-/** POST /signin → gin.H{"data": mintedToken} */
+/**
+ * POST /signin and POST /refresh → gin.H{"data": mintedToken, "refresh_token": ..., "expires_in": seconds}
+ *
+ * The access token in `data` only lasts `expires_in` seconds. POST /refresh with { refresh_token } returns a new
+ * pair, and the refresh token it was sent stops working.
+ */
 export interface SigninResponse {
   data: string;
+  refresh_token: string;
+  expires_in: number;
+}
+
+/**
+ * POST /signin for a user with two-factor authentication. POST /signin/2fa with { challenge, code } returns the
+ * SigninResponse; `code` is from their authenticator app, or one of their recovery codes.
+ */
+export interface TwoFactorChallengeResponse {
+  two_factor_required: true;
+  challenge: string;
+}
+
+/** Go: auth.TwoFactorStatus — GET /2fa */
+export interface TwoFactorStatus {
+  enabled: boolean;
+  recovery_codes_left: number;
+}
+
+/**
+ * Go: auth.TotpEnrollment — POST /2fa/enroll. `uri` is what a QR code for authenticator apps encodes; `secret` can
+ * be typed in instead. POST /2fa/confirm with a code from the app turns two-factor authentication on.
+ */
+export interface TotpEnrollment {
+  secret: string;
+  uri: string;
+}
+
+/** POST /2fa/confirm and POST /2fa/recovery_codes → gin.H{"recovery_codes": [...]}. They are only shown once. */
+export interface RecoveryCodesResponse {
+  recovery_codes: string[];
+}
+
+/** Go: auth.Session — a signed in device. GET /sessions → { sessions: Session[] } */
+export interface Session {
+  id: string;
+  user_id: string;
+  user_agent: string;
+  ip: string;
+  time_created: string;
+  time_last_used: string;
+  time_expires: string;
+  /** The session making the request */
+  current: boolean;
+}
+
+export interface SessionsResponse {
+  sessions: Session[];
 }
 
 /** POST /signup → gin.H{"data": "ok"} */
@@ -177,9 +352,35 @@ export interface SignupResponse {
 /** GET /messages/:room_id → gin.H{"messages": result} */
 export interface MessagesResponse {
   messages: Message[];
+  /** Pass as `before` for older messages. Absent on the oldest page. */
+  next_cursor?: string;
+  /** Pass as `after` for newer messages. Absent on the newest page. */
+  prev_cursor?: string;
 }
 
 /** POST /messages → gin.H{"message": r} */
 export interface MessageCreateResponse {
   message: Message;
 }
+
+/**
+ * Go: message.SearchResult (search.go)
+ *
+ * `snippet` is HTML: the message text in it is escaped and matches are
+ * wrapped in <mark></mark>, so it can be rendered as is.
+ */
+export interface SearchResult {
+  message: Message;
+  snippet: string;
+}
+
+/** GET /search/messages → gin.H{"results": [...], "next_cursor": "..."} */
+export interface SearchMessagesResponse {
+  results: SearchResult[];
+  next_cursor?: string;
+}
+
+/** POST /attachments → gin.H{"attachment": a} */
+export interface AttachmentUploadResponse {
+  attachment: Attachment;
+}
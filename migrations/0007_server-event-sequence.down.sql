@@ -0,0 +1,11 @@
+drop index open_discord.server_events_event_order_index;
+
+alter sequence open_discord.server_event_seq owned by none;
+
+alter table open_discord.server_events
+    drop column roles,
+    alter column event_order drop not null,
+    alter column event_order set default nextval('open_discord.rooms_default_order'::regclass),
+    alter column event_order type integer;
+
+alter sequence open_discord.server_event_seq as integer;
@@ -0,0 +1,18 @@
+alter sequence open_discord.server_event_seq as bigint;
+
+-- event_order was accidentally defaulted to the room ordering sequence in 0001. Move any existing rows out of the way
+-- of the real sequence so the unique index below holds.
+select setval('open_discord.server_event_seq',
+              coalesce((select max(event_order) from open_discord.server_events), 0) + 1,
+              false);
+
+alter table open_discord.server_events
+    alter column event_order type bigint,
+    alter column event_order set default nextval('open_discord.server_event_seq'::regclass),
+    alter column event_order set not null,
+    add column roles text[];
+
+alter sequence open_discord.server_event_seq owned by open_discord.server_events.event_order;
+
+create unique index server_events_event_order_index
+    on open_discord.server_events (event_order);
@@ -0,0 +1,9 @@
+alter table open_discord.user_room_stars
+    drop constraint user_room_stars_room_id_fkey,
+    add constraint user_room_stars_room_id_fkey
+        foreign key (room_id) references open_discord.rooms (id);
+
+alter table open_discord.messages
+    drop constraint messages_server_id_fk,
+    add constraint messages_server_id_fk
+        foreign key (room_id) references open_discord.rooms (id);
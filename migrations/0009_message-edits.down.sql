@@ -0,0 +1,4 @@
+drop table open_discord.message_edits;
+
+alter table open_discord.messages
+    drop column edited_at;
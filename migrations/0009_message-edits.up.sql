@@ -0,0 +1,13 @@
+alter table open_discord.messages
+    add column edited_at timestamp with time zone;
+
+-- Every prior version of an edited message. edited_at is when that version was replaced.
+create table open_discord.message_edits (
+    id         uuid                     not null default gen_random_uuid() primary key,
+    message_id uuid                     not null references open_discord.messages (id) on delete cascade,
+    message    text,
+    edited_at  timestamp with time zone not null default current_timestamp
+);
+
+create index message_edits_message_id_index
+    on open_discord.message_edits (message_id, edited_at);
@@ -0,0 +1,3 @@
+alter table open_discord.messages
+    drop column deleted_by,
+    drop column deleted_at;
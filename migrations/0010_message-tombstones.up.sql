@@ -0,0 +1,4 @@
+-- Deleted messages keep their row, with the text cleared, so pagination over a room stays stable
+alter table open_discord.messages
+    add column deleted_at timestamp with time zone,
+    add column deleted_by uuid references open_discord.users (id);
@@ -0,0 +1,4 @@
+drop index open_discord.messages_parent_id_index;
+
+alter table open_discord.messages
+    drop column parent_id;
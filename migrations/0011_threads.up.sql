@@ -0,0 +1,6 @@
+-- A reply's parent is always a top-level message in the same room
+alter table open_discord.messages
+    add column parent_id uuid references open_discord.messages (id) on delete cascade;
+
+create index messages_parent_id_index
+    on open_discord.messages (parent_id, timestamp);
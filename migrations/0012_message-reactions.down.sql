@@ -0,0 +1 @@
+drop table open_discord.message_reactions;
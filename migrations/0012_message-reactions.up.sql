@@ -0,0 +1,8 @@
+-- One row per user per emoji on a message
+create table open_discord.message_reactions (
+    message_id uuid                     not null references open_discord.messages (id) on delete cascade,
+    user_id    uuid                     not null references open_discord.users (id),
+    emoji      text                     not null,
+    created_at timestamp with time zone not null default current_timestamp,
+    primary key (message_id, user_id, emoji)
+);
@@ -0,0 +1,4 @@
+drop index open_discord.messages_search_vector_index;
+
+alter table open_discord.messages
+    drop column search_vector;
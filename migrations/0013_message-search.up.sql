@@ -0,0 +1,7 @@
+-- Full-text search over message text. Tombstones have empty text, so they never match.
+alter table open_discord.messages
+    add column search_vector tsvector
+        generated always as (to_tsvector('english', coalesce(message, ''))) stored;
+
+create index messages_search_vector_index
+    on open_discord.messages using gin (search_vector);
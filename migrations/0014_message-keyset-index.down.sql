@@ -0,0 +1 @@
+drop index open_discord.messages_room_id_timestamp_id_index;
@@ -0,0 +1,4 @@
+-- Supports paging through a room's top-level messages by (timestamp, id) in either direction
+create index messages_room_id_timestamp_id_index
+    on open_discord.messages (room_id, timestamp desc, id desc)
+    where parent_id is null;
@@ -0,0 +1,16 @@
+-- Uploaded files. The blob itself lives in the configured store under storage_key. An attachment is uploaded to a room
+-- first and linked to a message when that message is posted; deleting the room or the message deletes it too.
+create table open_discord.attachments (
+    id           uuid                     not null default gen_random_uuid() primary key,
+    room_id      uuid                     not null references open_discord.rooms (id) on delete cascade,
+    uploader_id  uuid                     not null references open_discord.users (id),
+    message_id   uuid references open_discord.messages (id) on delete cascade,
+    filename     text                     not null,
+    content_type text                     not null,
+    size         bigint                   not null,
+    storage_key  text                     not null,
+    created_at   timestamp with time zone not null default current_timestamp
+);
+
+create index attachments_message_id_index
+    on open_discord.attachments (message_id);
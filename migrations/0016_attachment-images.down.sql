@@ -0,0 +1,10 @@
+drop index open_discord.attachments_unprocessed_index;
+
+alter table open_discord.attachments
+    drop column processing_error,
+    drop column processed_at,
+    drop column thumbnail_type,
+    drop column thumbnail_key,
+    drop column blurhash,
+    drop column height,
+    drop column width;
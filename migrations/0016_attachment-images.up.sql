@@ -0,0 +1,14 @@
+-- Filled in by the image worker. Attachments it can't handle get processed_at and processing_error so they aren't
+-- retried forever.
+alter table open_discord.attachments
+    add column width            integer,
+    add column height           integer,
+    add column blurhash         text,
+    add column thumbnail_key    text,
+    add column thumbnail_type   text,
+    add column processed_at     timestamp with time zone,
+    add column processing_error text;
+
+create index attachments_unprocessed_index
+    on open_discord.attachments (created_at)
+    where processed_at is null;
@@ -0,0 +1,10 @@
+alter table open_discord.server_events
+    drop column user_ids;
+
+drop table open_discord.room_participants;
+
+delete from open_discord.rooms where kind = 'dm';
+
+alter table open_discord.rooms
+    drop constraint rooms_kind_check,
+    drop column kind;
@@ -0,0 +1,19 @@
+-- Direct messages are rooms of kind 'dm'. Instead of room roles they have a fixed set of participants, and only those
+-- participants can see them or their messages.
+alter table open_discord.rooms
+    add column kind varchar(16) not null default 'room',
+    add constraint rooms_kind_check check (kind in ('room', 'dm'));
+
+create table open_discord.room_participants (
+    room_id   uuid                     not null references open_discord.rooms (id) on delete cascade,
+    user_id   uuid                     not null references open_discord.users (id) on delete cascade,
+    joined_at timestamp with time zone not null default current_timestamp,
+    primary key (room_id, user_id)
+);
+
+create index room_participants_user_id_index
+    on open_discord.room_participants (user_id);
+
+-- Events about a DM are addressed to its participants rather than to roles
+alter table open_discord.server_events
+    add column user_ids uuid[];
@@ -0,0 +1,6 @@
+alter table open_discord.room_roles
+    drop constraint room_roles_view_check,
+    drop column permissions;
+
+alter table open_discord.roles
+    drop column permissions;
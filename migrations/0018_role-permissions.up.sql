@@ -0,0 +1,16 @@
+-- Roles carry a bitset of role.Permission. Admins get every bit, including ones added later. Everyone could create
+-- rooms before permissions existed, so the default role keeps that.
+alter table open_discord.roles
+    add column permissions bigint not null default 0;
+
+update open_discord.roles set permissions = -1 where name = 'admin';
+-- create_room
+update open_discord.roles set permissions = 1 where name = 'default';
+-- delete_any_message
+update open_discord.roles set permissions = 16 where name = 'moderator';
+
+-- A room role is a bitset of role.RoomPermission. Every room role lets its members view the room (1); posting (2) is
+-- granted separately, so a room can be read-only for some roles.
+alter table open_discord.room_roles
+    add column permissions integer not null default 3,
+    add constraint room_roles_view_check check (permissions & 1 = 1);
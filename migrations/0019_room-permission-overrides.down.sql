@@ -0,0 +1,18 @@
+alter table open_discord.server_events
+    drop column denied_roles;
+
+-- Deny-only overrides have no equivalent before this migration
+delete from open_discord.room_roles where allow & 1 = 0;
+
+alter table open_discord.room_roles
+    drop constraint room_roles_allow_deny_check,
+    drop column deny;
+
+alter table open_discord.room_roles
+    rename column allow to permissions;
+
+update open_discord.room_roles set permissions = permissions & 3;
+
+alter table open_discord.room_roles
+    alter column permissions set default 3,
+    add constraint room_roles_view_check check (permissions & 1 = 1);
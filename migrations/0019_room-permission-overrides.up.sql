@@ -0,0 +1,21 @@
+-- Room roles become allow/deny overrides of role.RoomPermission (view 1, post 2, react 4, attach 8) per role. A room
+-- with no role allowed to view is open to everyone, less what is denied; otherwise only roles allowed to view get in.
+-- When a user's roles disagree, allow wins, so an announcement room can deny post to default and allow it to staff.
+alter table open_discord.room_roles
+    drop constraint room_roles_view_check;
+
+alter table open_discord.room_roles
+    rename column permissions to allow;
+
+-- Reacting used to come with viewing, and attaching with posting
+update open_discord.room_roles
+set allow = allow | 4 | (case when allow & 2 = 2 then 8 else 0 end);
+
+alter table open_discord.room_roles
+    alter column allow set default 15,
+    add column deny integer not null default 0,
+    add constraint room_roles_allow_deny_check check (allow & deny = 0);
+
+-- Events about a room with denied roles are hidden from those roles
+alter table open_discord.server_events
+    add column denied_roles text[];
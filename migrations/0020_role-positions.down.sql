@@ -0,0 +1,3 @@
+alter table open_discord.roles
+    drop constraint roles_position_check,
+    drop column position;
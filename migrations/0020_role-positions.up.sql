@@ -0,0 +1,9 @@
+-- Roles are ranked by position, highest first. Users can only manage roles, and other users, ranked below their own
+-- highest role. Admin is on top and default, which everyone has, at the bottom.
+alter table open_discord.roles
+    add column position integer not null default 1,
+    add constraint roles_position_check check (position >= 0);
+
+update open_discord.roles set position = 1000 where name = 'admin';
+update open_discord.roles set position = 100 where name = 'moderator';
+update open_discord.roles set position = 0 where name = 'default';
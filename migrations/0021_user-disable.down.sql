@@ -0,0 +1,2 @@
+alter table open_discord.users
+    drop column disabled_at;
@@ -0,0 +1,3 @@
+-- Disabled users can't sign in, and the tokens they already have stop working
+alter table open_discord.users
+    add column disabled_at timestamptz;
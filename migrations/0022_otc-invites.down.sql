@@ -0,0 +1,18 @@
+drop table open_discord.signup_otc_redemptions;
+drop table open_discord.signup_otc_roles;
+
+alter table open_discord.signup_otcs
+    add column used bool default false;
+
+update open_discord.signup_otcs set used = uses >= max_uses or revoked_at is not null;
+update open_discord.signup_otcs set time_expires = time_created + interval '1 hour' where time_expires is null;
+
+alter table open_discord.signup_otcs
+    drop constraint signup_otcs_pkey,
+    drop column label,
+    drop column max_uses,
+    drop column uses,
+    drop column created_by,
+    drop column revoked_at;
+
+create index otc_idx on open_discord.signup_otcs (code);
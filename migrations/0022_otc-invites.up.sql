@@ -0,0 +1,31 @@
+-- OTCs become invites that can be used several times, may never expire (a null time_expires), and can grant roles
+-- besides default
+alter table open_discord.signup_otcs
+    add primary key (code),
+    add column label text,
+    add column max_uses int not null default 1 check (max_uses > 0),
+    add column uses int not null default 0 check (uses >= 0),
+    add column created_by uuid references open_discord.users (id) on delete set null,
+    add column revoked_at timestamptz;
+
+update open_discord.signup_otcs set uses = 1 where used;
+
+alter table open_discord.signup_otcs
+    drop column used;
+
+drop index open_discord.otc_idx;
+
+-- Roles granted on signup in addition to default
+create table open_discord.signup_otc_roles (
+    code uuid not null references open_discord.signup_otcs (code) on delete cascade,
+    role_id uuid not null references open_discord.roles (id) on delete cascade,
+    primary key (code, role_id)
+);
+
+-- Who signed up with which invite
+create table open_discord.signup_otc_redemptions (
+    code uuid not null references open_discord.signup_otcs (code) on delete cascade,
+    user_id uuid not null references open_discord.users (id) on delete cascade,
+    time_redeemed timestamptz not null default now(),
+    primary key (code, user_id)
+);
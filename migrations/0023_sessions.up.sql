@@ -0,0 +1,18 @@
+-- A session is a signed in device. It holds the hash of its current refresh token, which is swapped for a new one
+-- every time it is used. The previous hash is kept so that a stolen, already used refresh token can be recognised.
+create table open_discord.sessions (
+    id                          uuid        not null default gen_random_uuid() primary key,
+    user_id                     uuid        not null references open_discord.users (id) on delete cascade,
+    refresh_token_hash          bytea       not null unique,
+    previous_refresh_token_hash bytea,
+    user_agent                  text        not null default '',
+    ip                          text        not null default '',
+    time_created                timestamptz not null default now(),
+    time_last_used              timestamptz not null default now(),
+    time_rotated                timestamptz not null default now(),
+    time_expires                timestamptz not null,
+    revoked_at                  timestamptz
+);
+
+create index sessions_user_id_idx on open_discord.sessions (user_id);
+create index sessions_previous_refresh_token_hash_idx on open_discord.sessions (previous_refresh_token_hash);
@@ -0,0 +1,6 @@
+drop table open_discord.recovery_codes;
+
+alter table open_discord.users
+    drop column totp_secret,
+    drop column totp_enabled_at,
+    drop column totp_last_step;
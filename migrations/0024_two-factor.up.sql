@@ -0,0 +1,15 @@
+-- TOTP two-factor authentication. totp_secret is set when the user starts enrolling, and totp_enabled_at once they
+-- confirm it with a code from their app. totp_last_step is the time step of the last accepted code, so a code can't be
+-- used twice.
+alter table open_discord.users
+    add column totp_secret     bytea,
+    add column totp_enabled_at timestamptz,
+    add column totp_last_step  bigint not null default 0;
+
+-- Single-use codes for signing in without the app. Only their hashes are stored.
+create table open_discord.recovery_codes (
+    user_id   uuid        not null references open_discord.users (id) on delete cascade,
+    code_hash bytea       not null,
+    used_at   timestamptz,
+    primary key (user_id, code_hash)
+);
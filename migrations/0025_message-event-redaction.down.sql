@@ -0,0 +1 @@
+drop index open_discord.server_events_message_id_index;
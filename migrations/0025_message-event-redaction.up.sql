@@ -0,0 +1,12 @@
+-- Deleting a message rewrites the events that carried it, which are found by the message's ID in their payload
+create index server_events_message_id_index
+    on open_discord.server_events ((payload ->> 'id'))
+    where event_type in ('new_message', 'thread_reply', 'message_edited');
+
+-- Messages deleted before then still have their text in those events
+update open_discord.server_events e
+set payload = (e.payload::jsonb || jsonb_build_object('message', '', 'deleted_at', m.deleted_at))::json
+from open_discord.messages m
+where e.event_type in ('new_message', 'thread_reply', 'message_edited')
+  and e.payload ->> 'id' = m.id::text
+  and m.deleted_at is not null;